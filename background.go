@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// Background is the light that comes from far away, seen by rays that don't
+// hit anything.
+type Background interface {
+	// Radiance returns the light coming from direction, which doesn't have to
+	// be a unit vector.
+	Radiance(direction Vec3) Color
+}
+
+// Radiance makes a Color a Background that's the same in every direction.
+func (c Color) Radiance(direction Vec3) Color {
+	return c
+}
+
+// GradientBackground fades from Bottom straight down to Top straight up.
+type GradientBackground struct {
+	Bottom Color
+	Top    Color
+}
+
+func (g GradientBackground) Radiance(direction Vec3) Color {
+	// the unit vector's y ranges from [-1, 1], so it's transformed to [0, 1]
+	// for a linear interpolation
+	a := 0.5 * (direction.UnitVector().Y + 1)
+	return Color{g.Bottom.Vec.Scale(1 - a).Add(g.Top.Vec.Scale(a))}
+}
+
+// defaultSky is the background used when there isn't one, a sky that's a
+// light blue overhead.
+var defaultSky = GradientBackground{newColor(0.75, 0.85, 1), newColor(0.25, 0.55, 1)}
+
+// EnvironmentMap is a panorama of the surroundings in an equirectangular image,
+// where the left edge to the right edge goes all the way around and the top
+// row is straight up. Lighting a scene with a photo of a real place is the
+// easiest way to make reflections look real.
+type EnvironmentMap struct {
+	image *ImageTexture
+	// Rotation turns the panorama around the Y axis by this many radians.
+	Rotation float64
+	// Intensity scales the brightness of the panorama.
+	Intensity float64
+}
+
+// LoadEnvironmentMap reads an equirectangular Radiance HDR (.hdr), PNG, or
+// JPEG file. An HDR file is best, since the sun and other lights in a PNG or
+// JPEG are clipped to white.
+func LoadEnvironmentMap(path string) (*EnvironmentMap, error) {
+	if strings.ToLower(filepath.Ext(path)) != ".hdr" {
+		texture, err := LoadImageTexture(path)
+		if err != nil {
+			return nil, err
+		}
+		return &EnvironmentMap{image: texture, Intensity: 1}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := decodeRadianceHDR(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewEnvironmentMap(img), nil
+}
+
+// NewEnvironmentMap uses the linear colors of img as an environment map.
+func NewEnvironmentMap(img *HDRImage) *EnvironmentMap {
+	texture := &ImageTexture{width: img.Width, height: img.Height, pixels: img.Pix}
+	return &EnvironmentMap{image: texture, Intensity: 1}
+}
+
+func (e *EnvironmentMap) Radiance(direction Vec3) Color {
+	// Turning the direction the opposite way turns the panorama by Rotation.
+	d := vec.RotationY(-e.Rotation).Direction(direction.UnitVector())
+	// The middle of the panorama is towards -Z, and it goes around to the
+	// right from there, as seen from inside.
+	u := 0.5 + math.Atan2(d.X, -d.Z)/(2*math.Pi)
+	v := math.Acos(max(min(-d.Y, 1), -1)) / math.Pi
+	c := e.image.Value(u, v, d)
+	return Color{c.Vec.Scale(e.Intensity)}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestDefaultSky(t *testing.T) {
+	// the sky the renderer has always had
+	lightBlue := newColor(0.5, 0.7, 1)
+	for _, direction := range []Vec3{vec.New(0, 1, 0), vec.New(0, -1, 0), vec.New(1, 0, 0), vec.New(3, 1, -2)} {
+		a := 0.5*direction.UnitVector().Y + 1
+		want := white.Vec.Scale(1 - a).Add(lightBlue.Vec.Scale(a))
+		if got := defaultSky.Radiance(direction); got.Vec.Subtract(want).Length() > 1e-12 {
+			t.Errorf("sky towards %v is %v, want %v", direction, got, want)
+		}
+	}
+}
+
+func TestEnvironmentMap(t *testing.T) {
+	// four columns that each face a different way around the Y axis, with a
+	// bright top row and dark bottom row
+	img := &HDRImage{Width: 4, Height: 2}
+	for y := range 2 {
+		for x := range 4 {
+			img.Pix = append(img.Pix, newColor(float64(x+1), float64(2-y), 10))
+		}
+	}
+	env := NewEnvironmentMap(img)
+	tests := []struct {
+		direction Vec3
+		want      Color
+	}{
+		// the panorama goes from +Z around through -X, -Z, and +X
+		{vec.New(-1, 0.1, 1), newColor(1, 2, 10)},
+		{vec.New(-1, 0.1, -1), newColor(2, 2, 10)},
+		{vec.New(1, 0.1, -1), newColor(3, 2, 10)},
+		{vec.New(1, -0.1, 1), newColor(4, 1, 10)},
+	}
+	for _, test := range tests {
+		if got := env.Radiance(test.direction); got != test.want {
+			t.Errorf("environment towards %v is %v, want %v", test.direction, got, test.want)
+		}
+	}
+
+	// turning the panorama a quarter turn brings the last column around to
+	// where the one before it was
+	env.Rotation = math.Pi / 2
+	env.Intensity = 2
+	if got := env.Radiance(vec.New(1, 0.1, -1)); got != newColor(8, 4, 20) {
+		t.Errorf("expected the rotated, brightened environment to be (8, 4, 20), got %v", got)
+	}
+}
+
+func TestDecodeRadianceHDRHeader(t *testing.T) {
+	// files from other programs have more in their headers
+	data := "#?RGBE\n# made by hand\nGAMMA=1\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1\n\n-Y 1 +X 2\n" +
+		string([]byte{128, 64, 32, 129, 0, 0, 0, 0})
+	img, err := decodeRadianceHDR(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 2 || img.Height != 1 || img.Pix[0] != newColor(1, 0.5, 0.25) || img.Pix[1] != black {
+		t.Errorf("unexpected image %+v", img)
+	}
+
+	if _, err := decodeRadianceHDR(strings.NewReader("#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n")); err == nil {
+		t.Error("expected an error for the XYZE format")
+	}
+}
+
+func TestLoadSceneBackgrounds(t *testing.T) {
+	dir := t.TempDir()
+	img := &HDRImage{Width: 2, Height: 1, Pix: []Color{newColor(4, 4, 4), newColor(0.5, 0.5, 0.5)}}
+	f, err := os.Create(filepath.Join(dir, "sky.hdr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encodeRadianceHDR(f, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		background string
+		direction  Vec3
+		want       Color
+	}{
+		{`[0.1, 0.2, 0.3]`, vec.New(0, 1, 0), newColor(0.1, 0.2, 0.3)},
+		{`{"type": "gradient", "bottom": [1, 0, 0], "top": [0, 0, 1]}`, vec.New(0, 1, 0), newColor(0, 0, 1)},
+		{`{"type": "environment", "path": "sky.hdr"}`, vec.New(-1, 0, -1), newColor(4, 4, 4)},
+		{`{"type": "environment", "path": "sky.hdr", "rotation": 180, "intensity": 0.5}`, vec.New(-1, 0, -1), newColor(0.25, 0.25, 0.25)},
+	}
+	for _, test := range tests {
+		scene := `{"camera": {"background": ` + test.background + `}}`
+		_, opts, err := loadScene(strings.NewReader(scene), dir)
+		if err != nil {
+			t.Fatalf("%s: %v", test.background, err)
+		}
+		if got := opts.Background.Radiance(test.direction); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.background, test.want, got)
+		}
+	}
+
+	_, _, err = loadScene(strings.NewReader(`{"camera": {"background": {"type": "environment"}}}`), dir)
+	if err == nil || !strings.Contains(err.Error(), "camera: background: environment must have a path") {
+		t.Errorf("expected an error about the missing path, got %v", err)
+	}
+}
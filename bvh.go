@@ -0,0 +1,241 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// AABB is an axis-aligned bounding box. A point p is inside the box if Min <= p
+// <= Max for every axis.
+type AABB struct {
+	Min Vec3
+	Max Vec3
+}
+
+// emptyAABB contains nothing. The union of it and any other box is the other
+// box.
+var emptyAABB = AABB{
+	Min: vec.New(math.Inf(1), math.Inf(1), math.Inf(1)),
+	Max: vec.New(math.Inf(-1), math.Inf(-1), math.Inf(-1)),
+}
+
+// NewAABB returns the smallest box that contains both a and b, which can be
+// any two opposite corners.
+func NewAABB(a, b Vec3) AABB {
+	return AABB{vec.Min(a, b), vec.Max(a, b)}
+}
+
+func (b AABB) Union(other AABB) AABB {
+	return AABB{vec.Min(b.Min, other.Min), vec.Max(b.Max, other.Max)}
+}
+
+// isBounded reports whether the box is finite along every axis.
+func (b AABB) isBounded() bool {
+	for axis := range 3 {
+		if math.IsInf(b.Min.Axis(axis), 0) || math.IsInf(b.Max.Axis(axis), 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b AABB) Centroid() Vec3 {
+	return b.Min.Add(b.Max).Scale(0.5)
+}
+
+// LongestAxis returns 0, 1, or 2 for whichever of the X, Y, or Z sides of the
+// box is longest.
+func (b AABB) LongestAxis() int {
+	size := b.Max.Subtract(b.Min)
+	if size.X > size.Y && size.X > size.Z {
+		return 0
+	} else if size.Y > size.Z {
+		return 1
+	}
+	return 2
+}
+
+// Hit returns whether the ray passes through the box anywhere in the range
+// [tMin,tMax].
+func (b AABB) Hit(ray Ray, tMin float64, tMax float64) bool {
+	// Each pair of parallel planes that make up the sides of the box (a
+	// "slab") bounds an interval of t. The ray goes through the box if all
+	// three of those intervals overlap.
+	var ok bool
+	if tMin, tMax, ok = slab(b.Min.X, b.Max.X, ray.Origin.X, ray.Direction.X, tMin, tMax); !ok {
+		return false
+	}
+	if tMin, tMax, ok = slab(b.Min.Y, b.Max.Y, ray.Origin.Y, ray.Direction.Y, tMin, tMax); !ok {
+		return false
+	}
+	_, _, ok = slab(b.Min.Z, b.Max.Z, ray.Origin.Z, ray.Direction.Z, tMin, tMax)
+	return ok
+}
+
+func slab(min, max, origin, direction, tMin, tMax float64) (float64, float64, bool) {
+	inverse := 1 / direction
+	t0 := (min - origin) * inverse
+	t1 := (max - origin) * inverse
+	if t0 > t1 {
+		t0, t1 = t1, t0
+	}
+	tMin = math.Max(t0, tMin)
+	tMax = math.Min(t1, tMax)
+	return tMin, tMax, tMax >= tMin
+}
+
+// padded returns a copy of b that is at least delta wide along every axis, so
+// that flat geometry still has a box with some volume.
+func (b AABB) padded(delta float64) AABB {
+	size := b.Max.Subtract(b.Min)
+	if size.X < delta {
+		b.Min.X -= delta / 2
+		b.Max.X += delta / 2
+	}
+	if size.Y < delta {
+		b.Min.Y -= delta / 2
+		b.Max.Y += delta / 2
+	}
+	if size.Z < delta {
+		b.Min.Z -= delta / 2
+		b.Max.Z += delta / 2
+	}
+	return b
+}
+
+// BVH is a bounding volume hierarchy. It holds the same objects as the World it
+// was built from, but it can skip testing most of them for any given ray, so the
+// cost of a Hit grows logarithmically with the number of objects instead of
+// linearly.
+type BVH struct {
+	root Hittable
+	// unbounded holds objects like planes that go on forever. They can't be
+	// sorted into the tree, so they're tested against every ray.
+	unbounded World
+}
+
+// NewBVH builds a BVH containing every object in world. world itself is not
+// modified.
+func NewBVH(world World) *BVH {
+	bvh := &BVH{}
+	primitives := make([]bvhPrimitive, 0, len(world))
+	for _, object := range world {
+		if object == nil {
+			panic("cannot build a BVH with a nil object")
+		}
+		box := object.BoundingBox()
+		if !box.isBounded() {
+			bvh.unbounded = append(bvh.unbounded, object)
+			continue
+		}
+		primitives = append(primitives, bvhPrimitive{object, box, box.Centroid()})
+	}
+	if len(primitives) > 0 {
+		bvh.root = buildBVH(primitives)
+	}
+	return bvh
+}
+
+// objects returns every object in the BVH, in no particular order.
+func (b *BVH) objects() World {
+	objects := slices.Clone(b.unbounded)
+	var walk func(object Hittable)
+	walk = func(object Hittable) {
+		if node, ok := object.(*bvhNode); ok {
+			walk(node.left)
+			walk(node.right)
+			return
+		}
+		objects = append(objects, object)
+	}
+	if b.root != nil {
+		walk(b.root)
+	}
+	return objects
+}
+
+func (b *BVH) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	hit, record := b.unbounded.Hit(ray, tMin, tMax, rng)
+	if hit {
+		tMax = record.T
+	}
+	if b.root == nil {
+		return hit, record
+	}
+	if rootHit, rootRecord := b.root.Hit(ray, tMin, tMax, rng); rootHit {
+		return true, rootRecord
+	}
+	return hit, record
+}
+
+func (b *BVH) BoundingBox() AABB {
+	box := b.unbounded.BoundingBox()
+	if b.root != nil {
+		box = box.Union(b.root.BoundingBox())
+	}
+	return box
+}
+
+// bvhPrimitive caches the bounding box of an object so that it isn't
+// recalculated over and over while the tree is being built.
+type bvhPrimitive struct {
+	object   Hittable
+	box      AABB
+	centroid Vec3
+}
+
+// buildBVH recursively splits primitives in half along the longest axis of their
+// centroids. It reorders primitives in place.
+func buildBVH(primitives []bvhPrimitive) Hittable {
+	if len(primitives) == 1 {
+		return primitives[0].object
+	}
+
+	box := emptyAABB
+	centroids := emptyAABB
+	for _, p := range primitives {
+		box = box.Union(p.box)
+		centroids = centroids.Union(AABB{p.centroid, p.centroid})
+	}
+
+	axis := centroids.LongestAxis()
+	slices.SortFunc(primitives, func(a, b bvhPrimitive) int {
+		return cmp.Compare(a.centroid.Axis(axis), b.centroid.Axis(axis))
+	})
+	mid := len(primitives) / 2
+	return &bvhNode{
+		left:  buildBVH(primitives[:mid]),
+		right: buildBVH(primitives[mid:]),
+		box:   box,
+	}
+}
+
+type bvhNode struct {
+	left  Hittable
+	right Hittable
+	box   AABB
+}
+
+func (n *bvhNode) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	if !n.box.Hit(ray, tMin, tMax) {
+		return false, HitRecord{}
+	}
+
+	hitLeft, record := n.left.Hit(ray, tMin, tMax, rng)
+	if hitLeft {
+		// anything on the right has to be closer than the left hit to matter
+		tMax = record.T
+	}
+	if hitRight, rightRecord := n.right.Hit(ray, tMin, tMax, rng); hitRight {
+		return true, rightRecord
+	}
+	return hitLeft, record
+}
+
+func (n *bvhNode) BoundingBox() AABB {
+	return n.box
+}
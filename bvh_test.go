@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestBVHMatchesWorld(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	world := make(World, 0, 500)
+	for range 500 {
+		center := vec.RandomRange(rng, -20, 20)
+		world = append(world, Sphere{center, rng.Float64() + 0.1, Lambertian{white}})
+	}
+	bvh := NewBVH(world)
+
+	for range 2000 {
+		ray := Ray{Origin: vec.RandomRange(rng, -30, 30), Direction: vec.RandomUnit(rng)}
+		worldHit, worldRecord := world.Hit(ray, 0.001, math.Inf(1), nil)
+		bvhHit, bvhRecord := bvh.Hit(ray, 0.001, math.Inf(1), nil)
+		if worldHit != bvhHit {
+			t.Fatalf("ray %+v: world hit is %v but BVH hit is %v", ray, worldHit, bvhHit)
+		}
+		if worldHit && worldRecord.T != bvhRecord.T {
+			t.Fatalf("ray %+v: world hit at t=%v but BVH hit at t=%v", ray, worldRecord.T, bvhRecord.T)
+		}
+	}
+}
+
+func TestEmptyBVH(t *testing.T) {
+	bvh := NewBVH(World{})
+	if hit, _ := bvh.Hit(Ray{Origin: vec.New(0, 0, 0), Direction: vec.New(0, 0, -1)}, 0.001, math.Inf(1), nil); hit {
+		t.Fatal("an empty BVH should never be hit")
+	}
+}
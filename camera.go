@@ -1,12 +1,15 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"image"
+	"image/color"
 	"io"
 	"math"
-	"math/rand"
+	"math/rand/v2"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/Anthony-Fiddes/raytracing-1w/vec"
 )
@@ -25,10 +28,39 @@ type CameraOpts struct {
 	FocusDist float64
 	// DefocusAngle is the degrees
 	DefocusAngle float64
-	Out          io.Writer
-	Log          io.Writer
+	// Background is the light seen by rays that don't hit anything. If it's
+	// nil, there is a light blue sky.
+	Background Background
+	// Lights are aimed at directly from surfaces they light, which finds
+	// small lights far more often than waiting for rays to hit them by
+	// chance. MarkLights gets them from a World.
+	Lights []Light
+	// ToneMap is how colors that are too bright to display are brought into
+	// range. The default clips them.
+	ToneMap ToneMap
+	// Exposure brightens (or darkens, if it's negative) the image by this
+	// many stops before it's tone mapped.
+	Exposure float64
+	// Log is where progress and a summary of the render are written if
+	// Progress is nil.
+	Log io.Writer
+	// Progress is called after each tile is rendered, and once more when the
+	// whole image is done. It's called from the same goroutine as Render.
+	Progress func(Progress)
+	// ShutterOpen and ShutterClose are the times between which each sample is
+	// taken. Anything that moves in that time is blurred. If they're the same,
+	// every sample is taken at ShutterOpen.
+	ShutterOpen  float64
+	ShutterClose float64
+	// Progressive keeps adding passes of samples to the image after
+	// SamplesPerPixel have been taken, until the context given to RenderHDR
+	// is done. It's meant for renders with a time limit, which use all of it.
+	Progressive bool
 	// Parallel specifies whether the render uses multiple threads or not
 	Parallel bool
+	// Seed determines every random number used for the render, so the same
+	// Seed always produces the same image no matter how many threads are used.
+	Seed uint64
 }
 
 // camera is an object in the world
@@ -72,7 +104,6 @@ func NewCamera(opts CameraOpts) camera {
 	var (
 		defaultUp     = vec.New(0, 1, 0)
 		defaultLookAt = vec.New(0, 0, -1)
-		defaultOut    = os.Stdout
 		defaultLog    = os.Stderr
 	)
 
@@ -136,9 +167,10 @@ func NewCamera(opts CameraOpts) camera {
 		opts.FocusDist = opts.LookAt.Subtract(opts.Position).Length()
 	}
 
-	if opts.Out == nil {
-		opts.Out = defaultOut
+	if opts.ShutterClose < opts.ShutterOpen {
+		panic("ShutterClose cannot be before ShutterOpen")
 	}
+
 	if opts.Log == nil {
 		opts.Log = defaultLog
 	}
@@ -186,121 +218,255 @@ func calculateViewport(c camera) viewport {
 	}
 }
 
-func (c camera) Render(world Hittable) {
+// tileSize is the width and height in pixels of the squares that the image is
+// split into for rendering.
+const tileSize = 16
+
+// samplesPerPass is the most samples per pixel that are taken in one pass over
+// the image.
+const samplesPerPass = 4
+
+// Render returns the image that the camera captures of world.
+func (c camera) Render(world Hittable) *image.NRGBA64 {
+	img, _ := c.RenderContext(context.Background(), world)
+	return img
+}
+
+// RenderContext returns the image that the camera captures of world, unless ctx
+// is done first. In that case, it stops as soon as it can and returns the
+// image rendered so far along with ctx.Err().
+func (c camera) RenderContext(ctx context.Context, world Hittable) (*image.NRGBA64, error) {
+	img, err := c.RenderHDR(ctx, world)
+	return img.NRGBA64(), err
+}
+
+// RenderHDR is like RenderContext, but the image it returns keeps the linear
+// colors that were rendered.
+//
+// The image is rendered progressively in passes that each add a few samples to
+// every pixel, so a render that's stopped early is evenly converged rather
+// than missing a chunk. If Progressive is set, passes keep being added until
+// ctx is done. Every pass splits the image into tiles that are handed
+// out to a pool of workers, each of which renders whole tiles at a time into a
+// shared framebuffer. Workers never touch the same pixel during a pass, so the
+// framebuffer doesn't need to be locked.
+func (c camera) RenderHDR(ctx context.Context, world Hittable) (*HDRImage, error) {
+	numWorkers := 1
 	if c.Parallel {
-		c.renderParallel(world)
-		return
+		numWorkers = runtime.GOMAXPROCS(0)
 	}
-	c.render(world)
-}
 
-func (c camera) render(world Hittable) {
-	fmt.Fprintf(c.Out, "P3\n%d %d\n255\n", c.Width, c.height)
-	for j := 0; j < c.height; j++ {
-		fmt.Fprintf(c.Log, "\rScanlines remaining: %d ", c.height-j)
-		for i := 0; i < c.Width; i++ {
-			var pixel Color
-			for range c.SamplesPerPixel {
-				rayOrigin := c.Position
-				if c.DefocusAngle > 0 {
-					nudge := vec.RandomDisk()
-					rayOrigin = rayOrigin.Add(c.defocusDiskWidthVec.Scale(nudge.X))
-					rayOrigin = rayOrigin.Add(c.defocusDiskHeightVec.Scale(nudge.Y))
+	report := c.Progress
+	if report == nil {
+		report = c.logProgress
+	}
+
+	tiles := c.tiles()
+	numPasses := (c.SamplesPerPixel + samplesPerPass - 1) / samplesPerPass
+	fb := newFramebuffer(c.Width, c.height)
+	start := time.Now()
+	progress := Progress{TotalTiles: len(tiles) * numPasses, Stats: newRenderStats(c.MaxBounces)}
+	report(progress)
+
+	for pass := 0; (pass < numPasses || c.Progressive) && ctx.Err() == nil; pass++ {
+		samples := samplesPerPass
+		if pass < numPasses {
+			samples = min(samplesPerPass, c.SamplesPerPixel-pass*samplesPerPass)
+		} else {
+			// a pass past SamplesPerPixel in a progressive render
+			progress.TotalTiles += len(tiles)
+		}
+
+		// tileQueue is buffered to hold every tile so that it can be filled
+		// up front and the workers can just stop once it's empty.
+		tileQueue := make(chan tile, len(tiles))
+		for _, t := range tiles {
+			tileQueue <- t
+		}
+		close(tileQueue)
+
+		finished := make(chan RenderStats)
+		for range numWorkers {
+			go func() {
+				// Each worker has its own source of random numbers so that
+				// they don't contend over the lock on the global one.
+				pcg := rand.NewPCG(0, 0)
+				rng := rand.New(pcg)
+				for t := range tileQueue {
+					if ctx.Err() != nil {
+						// skip the rest of the tiles, but still account
+						// for them so that the pass ends
+						finished <- RenderStats{}
+						continue
+					}
+					finished <- c.renderTile(world, t, fb, pass, samples, pcg, rng)
 				}
+			}()
+		}
 
-				sampleXOffset := rand.Float64() - 0.5
-				sampleYOffset := rand.Float64() - 0.5
-				yPixelCenter := c.viewport.firstPixelCenter.Add(c.viewport.pixelDeltaY.Scale(float64(j) + sampleYOffset))
-				sampleCenter := yPixelCenter.Add(c.viewport.pixelDeltaX.Scale(float64(i) + sampleXOffset))
-				rayDirection := sampleCenter.Subtract(rayOrigin)
-				ray := Ray{rayOrigin, rayDirection}
-				pixel.Vec = pixel.Vec.Add(ray.Color(world, 0.001, math.Inf(1), c.MaxBounces).Vec)
+		// Waiting for every tile of the pass, even ones that were skipped,
+		// guarantees that no worker is still writing to the framebuffer
+		// once the loop is over.
+		for range tiles {
+			stats := <-finished
+			if stats.PrimaryRays == 0 {
+				continue
+			}
+			progress.Stats.merge(stats)
+			progress.TilesDone++
+			progress.Elapsed = time.Since(start)
+			progress.Stats.Duration = progress.Elapsed
+			remainingTiles := progress.TotalTiles - progress.TilesDone
+			progress.ETA = progress.Elapsed * time.Duration(remainingTiles) / time.Duration(progress.TilesDone)
+			if deadline, ok := ctx.Deadline(); ok {
+				progress.ETA = min(progress.ETA, time.Until(deadline))
+				if c.Progressive {
+					// the render goes on until the deadline
+					progress.ETA = time.Until(deadline)
+				}
 			}
-			pixel.Vec = pixel.Vec.Divide(float64(c.SamplesPerPixel))
-			writePPM(pixel, c.Out)
+			report(progress)
 		}
 	}
-	fmt.Fprint(c.Log, "\rDone.                    \n")
+
+	progress.Elapsed = time.Since(start)
+	progress.Stats.Duration = progress.Elapsed
+	progress.ETA = 0
+	progress.Finished = true
+	report(progress)
+	return fb.hdrImage(c.ToneMap, c.Exposure), ctx.Err()
 }
 
-func (c camera) renderParallel(world Hittable) {
-	// using a worker pool here because starting a goroutine for every sample
-	// was actually slower than the single-threaded version.
-	numWorkers := runtime.GOMAXPROCS(0)
-	// pixelPositions must be buffered as large as the number of samples per
-	// pixel or we'll deadlock when the main routine sends on it.
-	pixelPositions := make(chan pos, c.SamplesPerPixel)
-	samples := make(chan Vec3, c.SamplesPerPixel)
-	for i := 0; i < numWorkers; i++ {
-		go sampleWorker(c, world, pixelPositions, samples)
-	}
+// tile is a rectangle of pixels from (x0, y0) up to but not including (x1, y1).
+type tile struct {
+	x0, y0, x1, y1 int
+}
 
-	fmt.Fprintf(c.Out, "P3\n%d %d\n255\n", c.Width, c.height)
-	for j := 0; j < c.height; j++ {
-		fmt.Fprintf(c.Log, "\rScanlines remaining: %d ", c.height-j)
-		for i := 0; i < c.Width; i++ {
-			for range c.SamplesPerPixel {
-				pixelPositions <- pos{i, j}
-			}
+func (c camera) tiles() []tile {
+	var tiles []tile
+	for y := 0; y < c.height; y += tileSize {
+		for x := 0; x < c.Width; x += tileSize {
+			tiles = append(tiles, tile{x, y, min(x+tileSize, c.Width), min(y+tileSize, c.height)})
+		}
+	}
+	return tiles
+}
 
+// renderTile adds the given number of samples to every pixel in t for a pass
+// and returns stats for just that work. rng must draw from pcg.
+func (c camera) renderTile(world Hittable, t tile, fb *framebuffer, pass int, samples int, pcg *rand.PCG, rng *rand.Rand) RenderStats {
+	stats := newRenderStats(c.MaxBounces)
+	for j := t.y0; j < t.y1; j++ {
+		for i := t.x0; i < t.x1; i++ {
+			// Reseeding for every pixel means that the random numbers used
+			// for a pixel don't depend on which worker rendered it or what
+			// that worker rendered before.
+			pixelIndex := j*c.Width + i
+			pcg.Seed(c.Seed, uint64(pass)<<32|uint64(pixelIndex))
 			var pixel Color
-			for range c.SamplesPerPixel {
-				next := <-samples
-				pixel.Vec = pixel.Vec.Add(next)
+			for range samples {
+				ray := c.sampleRay(i, j, rng)
+				sample, bounces := ray.Color(world, c.Background, c.Lights, rng, 0.001, math.Inf(1), c.MaxBounces)
+				pixel.Vec = pixel.Vec.Add(sample.Vec)
+				stats.addPath(bounces)
 			}
-			pixel.Vec = pixel.Vec.Divide(float64(c.SamplesPerPixel))
-			writePPM(pixel, c.Out)
+			fb.add(pixelIndex, pixel, samples)
 		}
 	}
-	close(pixelPositions)
-	close(samples)
-	fmt.Fprint(c.Log, "\rDone.                    \n")
+	return stats
 }
 
-func sampleWorker(c camera, world Hittable, pixelPositions <-chan pos, samples chan<- Vec3) {
-	for pos := range pixelPositions {
-		rayOrigin := c.Position
-		if c.DefocusAngle > 0 {
-			nudge := vec.RandomDisk()
-			rayOrigin = rayOrigin.Add(c.defocusDiskWidthVec.Scale(nudge.X))
-			rayOrigin = rayOrigin.Add(c.defocusDiskHeightVec.Scale(nudge.Y))
-		}
+// framebuffer accumulates samples for every pixel of an image. The colors are
+// linear and aren't limited to [0,1] until the framebuffer is turned into an
+// image. Pixels are indexed from the top left, row by row.
+type framebuffer struct {
+	width  int
+	height int
+	// sums are the totals of all the samples taken for each pixel
+	sums []Color
+	// counts are how many samples were taken for each pixel
+	counts []int
+}
 
-		sampleXOffset := rand.Float64() - 0.5
-		sampleYOffset := rand.Float64() - 0.5
-		yPixelCenter := c.viewport.firstPixelCenter.Add(c.viewport.pixelDeltaY.Scale(float64(pos.j) + sampleYOffset))
-		sampleCenter := yPixelCenter.Add(c.viewport.pixelDeltaX.Scale(float64(pos.i) + sampleXOffset))
-		rayDirection := sampleCenter.Subtract(rayOrigin)
-		ray := Ray{rayOrigin, rayDirection}
-		samples <- ray.Color(world, 0.001, math.Inf(1), c.MaxBounces).Vec
+func newFramebuffer(width, height int) *framebuffer {
+	return &framebuffer{
+		width:  width,
+		height: height,
+		sums:   make([]Color, width*height),
+		counts: make([]int, width*height),
 	}
 }
 
-type pos struct {
-	i, j int
+// add adds the total of a number of samples to the pixel at index.
+func (fb *framebuffer) add(index int, total Color, samples int) {
+	fb.sums[index].Vec = fb.sums[index].Vec.Add(total.Vec)
+	fb.counts[index] += samples
 }
 
-func writePPM(c Color, w io.Writer) {
-	c.assertValid()
-	gammaR := linearToGamma(c.R())
-	gammaG := linearToGamma(c.G())
-	gammaB := linearToGamma(c.B())
-	scaledR := int(255.999 * gammaR)
-	scaledG := int(255.999 * gammaG)
-	scaledB := int(255.999 * gammaB)
-	fmt.Fprintf(w, "%d %d %d\n", scaledR, scaledG, scaledB)
+// pixel returns the average of the samples taken for the pixel at index, or
+// black if there weren't any.
+func (fb *framebuffer) pixel(index int) Color {
+	if fb.counts[index] == 0 {
+		return black
+	}
+	return Color{fb.sums[index].Vec.Divide(float64(fb.counts[index]))}
 }
 
-func linearToGamma(component float64) float64 {
-	if component > 0 {
-		return math.Sqrt(component)
+// hdrImage returns the average color of every pixel. toneMap and exposure (in
+// stops) are only used if the image is displayed.
+func (fb *framebuffer) hdrImage(toneMap ToneMap, exposure float64) *HDRImage {
+	img := &HDRImage{
+		Width:    fb.width,
+		Height:   fb.height,
+		Pix:      make([]Color, len(fb.sums)),
+		ToneMap:  toneMap,
+		Exposure: exposure,
+	}
+	for i := range img.Pix {
+		img.Pix[i] = fb.pixel(i)
+	}
+	return img
+}
+
+// sampleRay returns a ray from the camera through a random point in the pixel
+// at column i and row j.
+func (c camera) sampleRay(i, j int, rng *rand.Rand) Ray {
+	rayOrigin := c.Position
+	if c.DefocusAngle > 0 {
+		nudge := vec.RandomDisk(rng)
+		rayOrigin = rayOrigin.Add(c.defocusDiskWidthVec.Scale(nudge.X))
+		rayOrigin = rayOrigin.Add(c.defocusDiskHeightVec.Scale(nudge.Y))
+	}
+
+	sampleXOffset := rng.Float64() - 0.5
+	sampleYOffset := rng.Float64() - 0.5
+	yPixelCenter := c.viewport.firstPixelCenter.Add(c.viewport.pixelDeltaY.Scale(float64(j) + sampleYOffset))
+	sampleCenter := yPixelCenter.Add(c.viewport.pixelDeltaX.Scale(float64(i) + sampleXOffset))
+	rayDirection := sampleCenter.Subtract(rayOrigin)
+	rayTime := c.ShutterOpen
+	if c.ShutterClose > c.ShutterOpen {
+		rayTime += rng.Float64() * (c.ShutterClose - c.ShutterOpen)
 	}
-	return 0
+	return Ray{rayOrigin, rayDirection, rayTime}
+}
+
+// NRGBA64 converts c to an opaque, sRGB encoded color that can be stored in an
+// image. Components outside of [0,1] are clipped, so c should already be tone
+// mapped.
+func (c Color) NRGBA64() color.NRGBA64 {
+	c = ToneMapClamp.apply(c)
+	scaledR := uint16(65535.999 * linearToSRGB(c.R()))
+	scaledG := uint16(65535.999 * linearToSRGB(c.G()))
+	scaledB := uint16(65535.999 * linearToSRGB(c.B()))
+	return color.NRGBA64{scaledR, scaledG, scaledB, math.MaxUint16}
 }
 
 type Ray struct {
 	Origin    Vec3
 	Direction Vec3
+	// Time is when the ray was sent out, for objects that move while the
+	// shutter is open.
+	Time float64
 }
 
 func (r Ray) At(t float64) Vec3 {
@@ -312,30 +478,111 @@ func (r Ray) At(t float64) Vec3 {
 type Hittable interface {
 	// Hit returns whether the ray hits the Hittable within the range
 	// [tMin,tMax] along the ray. If hit is false, HitRecord is not valid.
-	Hit(ray Ray, tMin float64, tMax float64) (hit bool, record HitRecord)
+	// Most Hittables are solid surfaces that don't need rng, but any that hit
+	// rays at random, like ConstantMedium, must draw from it.
+	Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (hit bool, record HitRecord)
+	// BoundingBox returns a box that contains the whole Hittable.
+	BoundingBox() AABB
 }
 
-func (r Ray) Color(h Hittable, tMin float64, tMax float64, depth int) Color {
-	if depth <= 0 {
-		// no more light is gathered
-		return black
+// rouletteBounces is how many times a path bounces before Russian roulette
+// starts to end it early. The first few bounces carry most of the light, so
+// they're always traced.
+const rouletteBounces = 3
+
+// Color returns the light that travels back along the ray. background is the
+// light seen by rays that don't hit anything, or nil for the default sky.
+// Light is gathered directly from lights wherever a material is a BSDF. Any
+// randomness comes from rng. bounces is the number of times the path was
+// scattered, which is at most depth.
+//
+// A light can then be found two ways: by aiming at it, or by a scattered ray
+// happening to hit it. Aiming works best for small lights and rough
+// surfaces, and scattering works best for big lights and shiny surfaces, so
+// the light found each way is weighted by the power heuristic towards
+// whichever way was more likely to find it.
+//
+// After rouletteBounces, the path survives each bounce with a probability
+// that shrinks along with its throughput, and the light from paths that
+// survive is scaled up to make up for the ones that didn't. That spends less
+// time on paths that barely contribute without changing the average color.
+func (r Ray) Color(h Hittable, background Background, lights []Light, rng *rand.Rand, tMin float64, tMax float64, depth int) (light Color, bounces int) {
+	if background == nil {
+		background = defaultSky
 	}
 
-	if hit, record := h.Hit(r, tMin, tMax); hit {
-		scattered, newRay, attenuation := record.Material.Scatter(record)
-		if scattered {
-			colorVec := newRay.Color(h, tMin, tMax, depth-1).Vec.Hadamard(attenuation.Vec)
-			return Color{colorVec}
+	// throughput is how much of the light found along the path makes it back
+	// to the camera
+	throughput := white
+	ray := r
+	// scatterPDF is the probability density that the last bounce scattered
+	// the ray the way it went. It's 0 if light wasn't gathered directly from
+	// lights there, so any light that the ray hits counts in full.
+	var scatterPDF float64
+	for bounces < depth {
+		hit, record := h.Hit(ray, tMin, tMax, rng)
+		if !hit {
+			sky := background.Radiance(ray.Direction)
+			light.Vec = light.Vec.Add(sky.Vec.Hadamard(throughput.Vec))
+			for _, l := range lights {
+				if distant, ok := l.(distantLight); ok {
+					seen := distant.radiance(ray.Direction)
+					if seen == black {
+						continue
+					}
+					weight := 1.
+					if scatterPDF > 0 {
+						direction := ray.Direction.UnitVector()
+						weight = powerHeuristic(scatterPDF, l.PDF(ray.Origin, direction)/float64(len(lights)))
+					}
+					light.Vec = light.Vec.Add(seen.Vec.Hadamard(throughput.Vec).Scale(weight))
+				}
+			}
+			return light, bounces
 		}
-		// ray was absorbed
-		return black
-	}
 
-	unitDirection := r.Direction.UnitVector()
-	// unit vector's y ranges from [-1, 1], so we transform the range to [0, 1]
-	// to do a linear interpolation and get a nice gradient from white to blue
-	a := 0.5*unitDirection.Y + 1
-	lightBlue := newColor(0.5, 0.7, 1)
-	colorVec := white.Vec.Scale(1 - a).Add(lightBlue.Vec.Scale(a))
-	return Color{colorVec}
+		if emitter, ok := record.Material.(Emitter); ok {
+			weight := 1.
+			if scatterPDF > 0 && record.Light != nil {
+				direction := ray.Direction.UnitVector()
+				lightPDF := record.Light.PDF(ray.Origin, direction) / float64(len(lights))
+				weight = powerHeuristic(scatterPDF, lightPDF)
+			}
+			emitted := emitter.Emitted(record)
+			light.Vec = light.Vec.Add(emitted.Vec.Hadamard(throughput.Vec).Scale(weight))
+		}
+		bsdf, sampleLights := record.Material.(BSDF)
+		sampleLights = sampleLights && len(lights) > 0
+		if delta, ok := bsdf.(deltaBSDF); ok && delta.delta(record) {
+			// no light can be gathered in the one way it scatters
+			sampleLights = false
+		}
+		if sampleLights {
+			direct := directLight(h, lights, bsdf, record, rng, tMin)
+			light.Vec = light.Vec.Add(direct.Vec.Hadamard(throughput.Vec))
+		}
+
+		scattered, newRay, attenuation := record.Material.Scatter(record, rng)
+		if !scattered {
+			// ray was absorbed
+			return light, bounces
+		}
+		scatterPDF = 0
+		if sampleLights {
+			scatterPDF = bsdf.PDF(record, newRay.Direction.UnitVector())
+		}
+		throughput.Vec = throughput.Vec.Hadamard(attenuation.Vec)
+		ray = newRay
+		bounces++
+
+		if bounces >= rouletteBounces {
+			survival := min(max(throughput.R(), throughput.G(), throughput.B()), 0.95)
+			if rng.Float64() >= survival {
+				return light, bounces
+			}
+			throughput.Vec = throughput.Vec.Divide(survival)
+		}
+	}
+	// no more light is gathered
+	return light, bounces
 }
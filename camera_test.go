@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func renderTestScene(t *testing.T, parallel bool, seed uint64) *image.NRGBA64 {
+	t.Helper()
+	opts := simpleSceneCameraOpts
+	opts.Width = 40
+	opts.SamplesPerPixel = 4
+	opts.Parallel = parallel
+	opts.Seed = seed
+	img, err := renderSimpleScene(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.(*HDRImage).NRGBA64()
+}
+
+func TestRenderIsDeterministic(t *testing.T) {
+	// make sure there's more than one worker even on a single core machine
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	serial := renderTestScene(t, false, 42)
+	parallel := renderTestScene(t, true, 42)
+	if !bytes.Equal(serial.Pix, parallel.Pix) {
+		t.Error("serial and parallel renders with the same seed should be identical")
+	}
+
+	other := renderTestScene(t, true, 43)
+	if bytes.Equal(parallel.Pix, other.Pix) {
+		t.Error("renders with different seeds should not be identical")
+	}
+}
+
+func TestRandomSpheresSceneIsDeterministic(t *testing.T) {
+	opts := randomSpheresSceneCameraOpts
+	opts.Width = 20
+	opts.SamplesPerPixel = 1
+	opts.Seed = 7
+	first, err := renderRandomSpheres(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := renderRandomSpheres(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(first.(*HDRImage).Pix, second.(*HDRImage).Pix) {
+		t.Error("the random spheres scene should be the same for the same seed")
+	}
+}
+
+func TestRenderProgressAndStats(t *testing.T) {
+	opts := simpleSceneCameraOpts
+	opts.Width = 40
+	opts.SamplesPerPixel = 3
+	opts.MaxBounces = 10
+	var reports []Progress
+	opts.Progress = func(p Progress) {
+		reports = append(reports, p)
+	}
+	img, err := renderSimpleScene(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) < 2 {
+		t.Fatalf("expected progress to be reported at the start and end of the render, got %d reports", len(reports))
+	}
+	last := reports[len(reports)-1]
+	if !last.Finished || last.Percent() != 100 {
+		t.Errorf("expected the last report to be for a finished render, got %+v", last)
+	}
+
+	bounds := img.Bounds()
+	expectedRays := uint64(bounds.Dx() * bounds.Dy() * opts.SamplesPerPixel)
+	if last.Stats.PrimaryRays != expectedRays {
+		t.Errorf("expected %d primary rays, got %d", expectedRays, last.Stats.PrimaryRays)
+	}
+	if len(last.Stats.DepthHistogram) != opts.MaxBounces+1 {
+		t.Fatalf("expected %d histogram buckets, got %d", opts.MaxBounces+1, len(last.Stats.DepthHistogram))
+	}
+	var paths, bounces uint64
+	for depth, count := range last.Stats.DepthHistogram {
+		paths += count
+		bounces += uint64(depth) * count
+	}
+	if paths != last.Stats.PrimaryRays || bounces != last.Stats.Bounces {
+		t.Errorf("histogram has %d paths and %d bounces, but stats have %d and %d", paths, bounces, last.Stats.PrimaryRays, last.Stats.Bounces)
+	}
+}
+
+func TestRenderContextStopsEarly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := simpleSceneCameraOpts
+	opts.Width = 64
+	opts.SamplesPerPixel = 8
+	var last Progress
+	opts.Progress = func(p Progress) {
+		last = p
+		if p.TilesDone == 2 {
+			cancel()
+		}
+	}
+	img, err := renderSimpleScene(ctx, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the render to be canceled, got %v", err)
+	}
+	if img == nil {
+		t.Fatal("expected the partially rendered image")
+	}
+	if !last.Finished || last.TilesDone >= last.TotalTiles {
+		t.Errorf("expected the last report to be for an unfinished render, got %+v", last)
+	}
+
+	// the first tile was rendered, but the last one can't have been
+	bounds := img.Bounds()
+	if r, g, b, _ := img.At(0, 0).RGBA(); r+g+b == 0 {
+		t.Error("expected the first pixel to be rendered")
+	}
+	if r, g, b, _ := img.At(bounds.Max.X-1, bounds.Max.Y-1).RGBA(); r+g+b != 0 {
+		t.Error("expected the last pixel to be black since it wasn't rendered")
+	}
+}
+
+func TestProgressiveRenderKeepsGoing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := simpleSceneCameraOpts
+	opts.Width = 16
+	opts.SamplesPerPixel = 4
+	opts.Progressive = true
+	camera := NewCamera(opts)
+	budget := uint64(camera.Width*camera.height*opts.SamplesPerPixel) * 3
+	var last Progress
+	camera.Progress = func(p Progress) {
+		last = p
+		if p.Stats.PrimaryRays >= budget {
+			cancel()
+		}
+	}
+	img, err := camera.RenderHDR(ctx, World{Sphere{vec.New(0, 0, -1), 0.5, Lambertian{white}}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the render to go on until it was canceled, got %v", err)
+	}
+	if img == nil {
+		t.Fatal("expected the rendered image")
+	}
+	if last.Stats.PrimaryRays < budget {
+		t.Errorf("expected at least %d primary rays, got %d", budget, last.Stats.PrimaryRays)
+	}
+}
+
+func TestShutterBlursMovingSpheres(t *testing.T) {
+	sphere := MovingSphere{vec.New(0, 0, 0), vec.New(2, 0, 0), 0.5, Lambertian{white}}
+	down := vec.New(0, -1, 0)
+	if hit, _ := sphere.Hit(Ray{Origin: vec.New(0, 5, 0), Direction: down}, 0.001, math.Inf(1), nil); !hit {
+		t.Error("expected the sphere to be at Center0 at time 0")
+	}
+	if hit, _ := sphere.Hit(Ray{Origin: vec.New(0, 5, 0), Direction: down, Time: 1}, 0.001, math.Inf(1), nil); hit {
+		t.Error("expected the sphere to have moved away by time 1")
+	}
+	if hit, _ := sphere.Hit(Ray{Origin: vec.New(1, 5, 0), Direction: down, Time: 0.5}, 0.001, math.Inf(1), nil); !hit {
+		t.Error("expected the sphere to be halfway at time 0.5")
+	}
+
+	opts := simpleSceneCameraOpts
+	opts.ShutterOpen = 0.25
+	opts.ShutterClose = 0.75
+	camera := NewCamera(opts)
+	rng := rand.New(rand.NewPCG(1, 2))
+	seen := map[bool]bool{}
+	for range 100 {
+		ray := camera.sampleRay(0, 0, rng)
+		if ray.Time < opts.ShutterOpen || ray.Time > opts.ShutterClose {
+			t.Fatalf("ray time %v is outside of the shutter interval", ray.Time)
+		}
+		seen[ray.Time < 0.5] = true
+	}
+	if len(seen) != 2 {
+		t.Error("expected ray times to be spread over the shutter interval")
+	}
+}
+
+// glowingLambertian is a Lambertian surface that also gives off light.
+type glowingLambertian struct {
+	Lambertian
+	emit Color
+}
+
+func (g glowingLambertian) Emitted(record HitRecord) Color {
+	return g.emit
+}
+
+func TestRussianRouletteIsUnbiased(t *testing.T) {
+	// Inside a closed sphere that glows with 1 and reflects half of the light
+	// that reaches it, the light arriving anywhere is 1 + 1/2 + 1/4 + ... = 2.
+	// Russian roulette ends most of those paths early, so they have to be
+	// scaled up to get the same answer.
+	sphere := Sphere{vec.New(0, 0, 0), 10, glowingLambertian{Lambertian{newColor(0.5, 0.5, 0.5)}, white}}
+	rng := rand.New(rand.NewPCG(3, 4))
+	const samples = 50000
+	var sum float64
+	histogram := make([]int, 51)
+	for range samples {
+		ray := Ray{Origin: vec.New(0, 0, 0), Direction: vec.RandomUnit(rng)}
+		light, bounces := ray.Color(sphere, nil, nil, rng, 0.001, math.Inf(1), 50)
+		sum += light.R()
+		histogram[bounces]++
+	}
+	if mean := sum / samples; math.Abs(mean-2) > 0.05 {
+		t.Errorf("expected the light to average 2, got %v", mean)
+	}
+	if histogram[50] != 0 {
+		t.Errorf("expected Russian roulette to end every path before MaxBounces, but %d weren't", histogram[50])
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+// HDRImage is a render in linear color that hasn't been squeezed into the
+// range that can be displayed yet. It's an image.Image whose pixels are
+// exposed and tone mapped as they're read, but it can also be written out as a
+// float image (PFM, Radiance HDR, or OpenEXR) without losing any of its
+// dynamic range.
+type HDRImage struct {
+	Width  int
+	Height int
+	// Pix are the linear colors of the image from the top left, row by row
+	Pix []Color
+	// ToneMap and Exposure are applied to the colors that At returns. They
+	// don't affect float images.
+	ToneMap  ToneMap
+	Exposure float64
+}
+
+// Linear returns the linear color of the pixel at (x, y).
+func (img *HDRImage) Linear(x, y int) Color {
+	return img.Pix[y*img.Width+x]
+}
+
+func (img *HDRImage) ColorModel() color.Model {
+	return color.NRGBA64Model
+}
+
+func (img *HDRImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, img.Width, img.Height)
+}
+
+func (img *HDRImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return color.NRGBA64{}
+	}
+	return img.display(img.Linear(x, y))
+}
+
+func (img *HDRImage) display(c Color) color.NRGBA64 {
+	return img.ToneMap.apply(exposed(c, img.Exposure)).NRGBA64()
+}
+
+// NRGBA64 tone maps the whole image into one that can be displayed.
+func (img *HDRImage) NRGBA64() *image.NRGBA64 {
+	out := image.NewNRGBA64(img.Bounds())
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			out.SetNRGBA64(x, y, img.display(img.Linear(x, y)))
+		}
+	}
+	return out
+}
+
+// isFloatFormat reports whether format keeps linear colors rather than
+// displayable ones.
+func isFloatFormat(format string) bool {
+	return format == "pfm" || format == "hdr" || format == "exr"
+}
+
+// errNotHDR is returned when a float format is asked for but the image has
+// already been tone mapped.
+var errNotHDR = errors.New("float formats can only be written from an HDR render")
+
+// encodeFloatImage writes img to w as a pfm, hdr, or exr.
+func encodeFloatImage(w io.Writer, format string, img image.Image) error {
+	hdr, ok := img.(*HDRImage)
+	if !ok {
+		return errNotHDR
+	}
+	switch format {
+	case "pfm":
+		return encodePFM(w, hdr)
+	case "hdr":
+		return encodeRadianceHDR(w, hdr)
+	case "exr":
+		return encodeEXR(w, hdr)
+	}
+	return fmt.Errorf("unsupported float image format %q", format)
+}
+
+// encodePFM writes img to w as a color Portable Float Map. Rows are written
+// from the bottom up as little-endian 32-bit floats, which the negative scale
+// in the header signals.
+func encodePFM(w io.Writer, img *HDRImage) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", img.Width, img.Height)
+	var buf [12]byte
+	for y := img.Height - 1; y >= 0; y-- {
+		for x := 0; x < img.Width; x++ {
+			c := img.Linear(x, y)
+			binary.LittleEndian.PutUint32(buf[0:], math.Float32bits(float32(sanitize(c.R()))))
+			binary.LittleEndian.PutUint32(buf[4:], math.Float32bits(float32(sanitize(c.G()))))
+			binary.LittleEndian.PutUint32(buf[8:], math.Float32bits(float32(sanitize(c.B()))))
+			bw.Write(buf[:])
+		}
+	}
+	return bw.Flush()
+}
+
+// encodeRadianceHDR writes img to w in the Radiance RGBE format, with each
+// scanline run-length encoded when it's a width that the format allows.
+func encodeRadianceHDR(w io.Writer, img *HDRImage) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", img.Height, img.Width)
+	scanline := make([][4]byte, img.Width)
+	for y := 0; y < img.Height; y++ {
+		for x := range scanline {
+			scanline[x] = toRGBE(img.Linear(x, y))
+		}
+		if img.Width < 8 || img.Width > 0x7fff {
+			// these widths can't be run-length encoded
+			for _, rgbe := range scanline {
+				bw.Write(rgbe[:])
+			}
+			continue
+		}
+		bw.Write([]byte{2, 2, byte(img.Width >> 8), byte(img.Width)})
+		channel := make([]byte, img.Width)
+		for i := range 4 {
+			for x, rgbe := range scanline {
+				channel[x] = rgbe[i]
+			}
+			writeRLE(bw, channel)
+		}
+	}
+	return bw.Flush()
+}
+
+// decodeRadianceHDR reads a Radiance RGBE image, like the ones that
+// encodeRadianceHDR writes. Only the usual top to bottom, left to right
+// orientation is supported.
+func decodeRadianceHDR(r io.Reader) (*HDRImage, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(magic, "#?") {
+		return nil, errors.New("not a Radiance HDR image")
+	}
+	// the header is a list of variables that ends at a blank line
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format, ok := strings.CutPrefix(line, "FORMAT="); ok && format != "32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported Radiance HDR format %q", format)
+		}
+	}
+	var width, height int
+	if _, err := fmt.Fscanf(br, "-Y %d +X %d\n", &height, &width); err != nil {
+		return nil, fmt.Errorf("unsupported Radiance HDR resolution: %w", err)
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid Radiance HDR size %dx%d", width, height)
+	}
+
+	img := &HDRImage{Width: width, Height: height, Pix: make([]Color, 0, width*height)}
+	scanline := make([][4]byte, width)
+	for range height {
+		if err := readRGBEScanline(br, scanline); err != nil {
+			return nil, err
+		}
+		for _, rgbe := range scanline {
+			img.Pix = append(img.Pix, fromRGBE(rgbe))
+		}
+	}
+	return img, nil
+}
+
+// readRGBEScanline reads one row of pixels into scanline, which may or may not
+// be run-length encoded.
+func readRGBEScanline(r *bufio.Reader, scanline [][4]byte) error {
+	var start [4]byte
+	if _, err := io.ReadFull(r, start[:]); err != nil {
+		return err
+	}
+	if start[0] != 2 || start[1] != 2 || start[2]&0x80 != 0 {
+		// a flat scanline
+		scanline[0] = start
+		for x := 1; x < len(scanline); x++ {
+			if _, err := io.ReadFull(r, scanline[x][:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if int(start[2])<<8|int(start[3]) != len(scanline) {
+		return errors.New("Radiance HDR scanline has the wrong width")
+	}
+	// each channel is run-length encoded separately
+	for channel := range 4 {
+		for x := 0; x < len(scanline); {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				count -= 128
+				if x+int(count) > len(scanline) {
+					return errors.New("Radiance HDR run overflows its scanline")
+				}
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				for range count {
+					scanline[x][channel] = value
+					x++
+				}
+				continue
+			}
+			if count == 0 || x+int(count) > len(scanline) {
+				return errors.New("invalid Radiance HDR run")
+			}
+			for range count {
+				if scanline[x][channel], err = r.ReadByte(); err != nil {
+					return err
+				}
+				x++
+			}
+		}
+	}
+	return nil
+}
+
+// fromRGBE unpacks a shared-exponent RGBE pixel.
+func fromRGBE(rgbe [4]byte) Color {
+	if rgbe[3] == 0 {
+		return black
+	}
+	scale := math.Ldexp(1, int(rgbe[3])-128-8)
+	return newColor(float64(rgbe[0])*scale, float64(rgbe[1])*scale, float64(rgbe[2])*scale)
+}
+
+// toRGBE packs c into a shared-exponent RGBE pixel.
+func toRGBE(c Color) [4]byte {
+	r, g, b := sanitize(c.R()), sanitize(c.G()), sanitize(c.B())
+	brightest := max(r, g, b)
+	if brightest < 1e-32 {
+		return [4]byte{}
+	}
+	mantissa, exponent := math.Frexp(brightest)
+	if exponent > 127 || math.IsInf(brightest, 1) {
+		// too bright to represent, so use the brightest color there is
+		return [4]byte{255, 255, 255, 255}
+	}
+	scale := mantissa * 256 / brightest
+	return [4]byte{byte(r * scale), byte(g * scale), byte(b * scale), byte(exponent + 128)}
+}
+
+// sanitize replaces components that can't be stored in a float image with 0.
+func sanitize(component float64) float64 {
+	if !isValidColor(component) {
+		return 0
+	}
+	return component
+}
+
+// writeRLE writes one channel of a scanline using the run-length encoding
+// from Radiance. A byte above 128 is followed by a value that's repeated that
+// many times minus 128, and any other byte is followed by that many literal
+// values.
+func writeRLE(w *bufio.Writer, data []byte) {
+	const (
+		minRun  = 4
+		maxRun  = 127
+		maxDump = 128
+	)
+	for start := 0; start < len(data); {
+		// find the next run that's worth encoding
+		runStart := start
+		runLength := 0
+		for runStart < len(data) {
+			runLength = 1
+			for runStart+runLength < len(data) && runLength < maxRun && data[runStart+runLength] == data[runStart] {
+				runLength++
+			}
+			if runLength >= minRun {
+				break
+			}
+			runStart += runLength
+		}
+		if runLength < minRun {
+			runStart = len(data)
+		}
+
+		// write the literal values before it
+		for start < runStart {
+			n := min(runStart-start, maxDump)
+			w.WriteByte(byte(n))
+			w.Write(data[start : start+n])
+			start += n
+		}
+		if runStart < len(data) {
+			w.WriteByte(byte(128 + runLength))
+			w.WriteByte(data[runStart])
+			start = runStart + runLength
+		}
+	}
+}
+
+// encodeEXR writes img to w as a single-part, uncompressed, scanline OpenEXR
+// file with 32-bit float R, G, and B channels.
+func encodeEXR(w io.Writer, img *HDRImage) error {
+	var header []byte
+	le := binary.LittleEndian
+	attribute := func(name, kind string, value []byte) {
+		header = append(header, name...)
+		header = append(header, 0)
+		header = append(header, kind...)
+		header = append(header, 0)
+		header = le.AppendUint32(header, uint32(len(value)))
+		header = append(header, value...)
+	}
+	box := func(xMin, yMin, xMax, yMax int) []byte {
+		var b []byte
+		for _, v := range []int{xMin, yMin, xMax, yMax} {
+			b = le.AppendUint32(b, uint32(int32(v)))
+		}
+		return b
+	}
+	float := func(f float32) []byte {
+		return le.AppendUint32(nil, math.Float32bits(f))
+	}
+
+	// channels have to be listed in alphabetical order
+	const floatPixels = 2
+	var channels []byte
+	for _, name := range []string{"B", "G", "R"} {
+		channels = append(channels, name...)
+		channels = append(channels, 0)
+		channels = le.AppendUint32(channels, floatPixels)
+		// pLinear and three reserved bytes
+		channels = append(channels, 0, 0, 0, 0)
+		// x and y sampling
+		channels = le.AppendUint32(channels, 1)
+		channels = le.AppendUint32(channels, 1)
+	}
+	channels = append(channels, 0)
+
+	// magic number and version 2 with no flags
+	header = le.AppendUint32(header, 20000630)
+	header = le.AppendUint32(header, 2)
+	attribute("channels", "chlist", channels)
+	attribute("compression", "compression", []byte{0})
+	attribute("dataWindow", "box2i", box(0, 0, img.Width-1, img.Height-1))
+	attribute("displayWindow", "box2i", box(0, 0, img.Width-1, img.Height-1))
+	attribute("lineOrder", "lineOrder", []byte{0})
+	attribute("pixelAspectRatio", "float", float(1))
+	attribute("screenWindowCenter", "v2f", append(float(0), float(0)...))
+	attribute("screenWindowWidth", "float", float(1))
+	header = append(header, 0)
+
+	// Every scanline is its own chunk, and the offset table that follows the
+	// header says where each one starts.
+	const channelCount = 3
+	lineSize := img.Width * channelCount * 4
+	chunkSize := 8 + lineSize
+	offset := uint64(len(header) + 8*img.Height)
+	for range img.Height {
+		header = le.AppendUint64(header, offset)
+		offset += uint64(chunkSize)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(header)
+	chunk := make([]byte, chunkSize)
+	for y := 0; y < img.Height; y++ {
+		le.PutUint32(chunk[0:], uint32(y))
+		le.PutUint32(chunk[4:], uint32(lineSize))
+		for x := 0; x < img.Width; x++ {
+			c := img.Linear(x, y)
+			for i, component := range []float64{c.B(), c.G(), c.R()} {
+				at := 8 + (i*img.Width+x)*4
+				le.PutUint32(chunk[at:], math.Float32bits(float32(sanitize(component))))
+			}
+		}
+		bw.Write(chunk)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// testHDRImage has a gradient that goes well past 1, some flat runs, and a
+// pixel that a broken material could produce.
+func testHDRImage(width, height int) *HDRImage {
+	img := &HDRImage{Width: width, Height: height, Pix: make([]Color, width*height)}
+	rng := rand.New(rand.NewPCG(3, 4))
+	for y := range height {
+		for x := range width {
+			c := newColor(float64(x)/4, float64(y), 0.5)
+			if x%3 == 0 {
+				c = Color{vec.RandomRange(rng, 0, 20)}
+			}
+			img.Pix[y*width+x] = c
+		}
+	}
+	img.Pix[0] = newColor(math.NaN(), -1, 2)
+	return img
+}
+
+func TestEncodePFM(t *testing.T) {
+	img := testHDRImage(5, 3)
+	var buf bytes.Buffer
+	if err := encodePFM(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(&buf)
+	var width, height int
+	var scale float64
+	if _, err := fmt.Fscanf(r, "PF\n%d %d\n%f\n", &width, &height, &scale); err != nil {
+		t.Fatal(err)
+	}
+	if width != 5 || height != 3 || scale != -1 {
+		t.Fatalf("got header %d %d %v", width, height, scale)
+	}
+	floats := make([]float32, width*height*3)
+	if err := binary.Read(r, binary.LittleEndian, floats); err != nil {
+		t.Fatal(err)
+	}
+	for y := range height {
+		for x := range width {
+			// rows go from the bottom up
+			i := ((height-1-y)*width + x) * 3
+			got := newColor(float64(floats[i]), float64(floats[i+1]), float64(floats[i+2]))
+			want := img.Linear(x, y)
+			want = newColor(sanitize(want.R()), sanitize(want.G()), sanitize(want.B()))
+			if got.Vec.Subtract(want.Vec).Length() > 1e-5 {
+				t.Errorf("pixel (%d, %d) is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeRadianceHDR(t *testing.T) {
+	// one width that's run-length encoded and one that isn't
+	for _, width := range []int{300, 5} {
+		img := testHDRImage(width, 4)
+		// a long run of the same color
+		for x := range width {
+			img.Pix[2*width+x] = newColor(3, 2, 1)
+		}
+		var buf bytes.Buffer
+		if err := encodeRadianceHDR(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeRadianceHDR(&buf)
+		if err != nil {
+			t.Fatalf("width %d: %v", width, err)
+		}
+		for i, want := range img.Pix {
+			want = newColor(sanitize(want.R()), sanitize(want.G()), sanitize(want.B()))
+			// RGBE truncates every channel to 8 bits relative to the
+			// brightest one
+			tolerance := max(want.R(), want.G(), want.B()) / 64
+			if got.Pix[i].Vec.Subtract(want.Vec).Length() > tolerance {
+				t.Fatalf("width %d: pixel %d is %v, want %v", width, i, got.Pix[i], want)
+			}
+		}
+	}
+}
+
+func TestEncodeEXR(t *testing.T) {
+	img := testHDRImage(6, 4)
+	var buf bytes.Buffer
+	if err := encodeEXR(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	le := binary.LittleEndian
+	if le.Uint32(data) != 20000630 || le.Uint32(data[4:]) != 2 {
+		t.Fatal("bad magic number or version")
+	}
+
+	// skip the attributes, checking that the ones every file needs are there
+	attributes := map[string]string{}
+	at := 8
+	for data[at] != 0 {
+		name, rest, _ := bytes.Cut(data[at:], []byte{0})
+		kind, rest, _ := bytes.Cut(rest, []byte{0})
+		size := int(le.Uint32(rest))
+		attributes[string(name)] = string(kind)
+		at += len(name) + 1 + len(kind) + 1 + 4 + size
+	}
+	at++
+	for _, name := range []string{"channels", "compression", "dataWindow", "displayWindow", "lineOrder", "pixelAspectRatio", "screenWindowCenter", "screenWindowWidth"} {
+		if _, ok := attributes[name]; !ok {
+			t.Errorf("missing required attribute %q", name)
+		}
+	}
+
+	for y := range img.Height {
+		offset := int(le.Uint64(data[at+8*y:]))
+		chunk := data[offset:]
+		if got := int(le.Uint32(chunk)); got != y {
+			t.Fatalf("chunk %d is for line %d", y, got)
+		}
+		chunk = chunk[8:]
+		for x := range img.Width {
+			value := func(channel int) float64 {
+				return float64(math.Float32frombits(le.Uint32(chunk[(channel*img.Width+x)*4:])))
+			}
+			got := newColor(value(2), value(1), value(0))
+			want := img.Linear(x, y)
+			want = newColor(sanitize(want.R()), sanitize(want.G()), sanitize(want.B()))
+			if got.Vec.Subtract(want.Vec).Length() > 1e-5 {
+				t.Errorf("pixel (%d, %d) is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestFloatFormatsNeedHDR(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	if err := encodeImage(&bytes.Buffer{}, "exr", img); !errors.Is(err, errNotHDR) {
+		t.Errorf("expected errNotHDR, got %v", err)
+	}
+}
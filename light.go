@@ -0,0 +1,471 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// Light is something that light can be gathered from directly. Aiming rays at
+// lights finds small ones far more often than waiting for rays that bounce
+// around the scene to hit them by chance.
+type Light interface {
+	// Sample picks a random point on the light to gather light from at
+	// origin. ok is false if there's no light to be had.
+	Sample(origin Vec3, rng *rand.Rand) (sample LightSample, ok bool)
+	// PDF returns the probability density, per unit of solid angle, that
+	// Sample picks direction, a unit vector, from origin.
+	PDF(origin Vec3, direction Vec3) float64
+}
+
+// LightSample is light that might arrive at a point from a Light, if nothing
+// is in the way.
+type LightSample struct {
+	// Direction is the unit vector from the point towards the light, and
+	// Distance is how far away the light is in that direction.
+	Direction Vec3
+	Distance  float64
+	// Radiance is the light that arrives along Direction.
+	Radiance Color
+	// PDF is the probability density that Direction was picked, per unit of
+	// solid angle.
+	PDF float64
+	// Delta is true for a light that shines from exactly one direction, like
+	// a PointLight, which only aiming at it can find. Radiance is then all of
+	// the light that arrives from it, and PDF isn't used.
+	Delta bool
+}
+
+// MarkLights returns a copy of world with the spheres, quads, and triangles
+// whose materials are Emitters marked as lights, along with those lights for
+// CameraOpts.Lights. Hitting a marked light records it as the Light that was
+// hit, so that it isn't counted again after being sampled. The marked world is
+// the one to render, or to put in a BVH. MarkLights looks inside nested Worlds,
+// like boxes, and BVHs, like meshes, which are rebuilt if they have lights in
+// them. Lights inside anything else, like a Transformed, aren't where they
+// appear to be, so they're left alone; see emitsLight. world itself is not
+// modified.
+func MarkLights(world World) (World, []Light) {
+	marked := make(World, len(world))
+	var lights []Light
+	for i, object := range world {
+		marked[i] = object
+		mark := func(light lightObject, material Material) {
+			if _, ok := material.(Emitter); ok {
+				marked[i] = foundLight{light}
+				lights = append(lights, foundLight{light})
+			}
+		}
+		switch o := object.(type) {
+		case World:
+			inner, innerLights := MarkLights(o)
+			marked[i] = inner
+			lights = append(lights, innerLights...)
+		case *BVH:
+			inner, innerLights := MarkLights(o.objects())
+			if len(innerLights) > 0 {
+				marked[i] = NewBVH(inner)
+				lights = append(lights, innerLights...)
+			}
+		case foundLight:
+			lights = append(lights, o)
+		case Sphere:
+			mark(o, o.Material)
+		case Quad:
+			mark(o, o.Material)
+		case Triangle:
+			mark(o, o.Material)
+		}
+	}
+	return marked, lights
+}
+
+// emitsLight reports whether object has a sphere, quad, or triangle in it that
+// MarkLights would mark as a light if it could find it.
+func emitsLight(object Hittable) bool {
+	switch o := object.(type) {
+	case World:
+		return slices.ContainsFunc(o, emitsLight)
+	case *BVH:
+		return slices.ContainsFunc(o.objects(), emitsLight)
+	case *Transformed:
+		return emitsLight(o.object)
+	case foundLight:
+		return true
+	case Sphere:
+		_, ok := o.Material.(Emitter)
+		return ok
+	case Quad:
+		_, ok := o.Material.(Emitter)
+		return ok
+	case Triangle:
+		_, ok := o.Material.(Emitter)
+		return ok
+	}
+	return false
+}
+
+// foundLight is an object that MarkLights marked as a Light. Hitting it
+// records it as the Light that was hit.
+type foundLight struct {
+	lightObject
+}
+
+// lightObject is an object that's a Light too, like a Sphere, Quad, or
+// Triangle.
+type lightObject interface {
+	Hittable
+	Light
+}
+
+func (l foundLight) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	hit, record := l.lightObject.Hit(ray, tMin, tMax, rng)
+	if hit {
+		record.Light = l
+	}
+	return hit, record
+}
+
+// Sample picks a direction towards the sphere uniformly from the cone of
+// directions that it fills as seen from origin. If origin is inside the
+// sphere, a point anywhere on it is picked instead.
+func (s Sphere) Sample(origin Vec3, rng *rand.Rand) (LightSample, bool) {
+	toCenter := s.Center.Subtract(origin)
+	distanceSquared := toCenter.LengthSquared()
+	radiusSquared := s.Radius * s.Radius
+	if distanceSquared <= radiusSquared {
+		outwardNormal := vec.RandomUnit(rng)
+		point := s.Center.Add(outwardNormal.Scale(s.Radius))
+		record := NewHitRecord(Ray{Origin: origin, Direction: point.Subtract(origin)}, 1, outwardNormal, point, s.Material)
+		record.U, record.V = sphereTexCoords(outwardNormal)
+		return areaLightSample(record, 4*math.Pi*radiusSquared)
+	}
+
+	// 1 - cos(theta max) is written this way because it's too close to 0 to
+	// calculate directly when the sphere is small and far away.
+	cosThetaMax := math.Sqrt(1 - radiusSquared/distanceSquared)
+	oneMinusCosThetaMax := radiusSquared / distanceSquared / (1 + cosThetaMax)
+	cosTheta := 1 - rng.Float64()*oneMinusCosThetaMax
+	sinThetaSquared := 1 - cosTheta*cosTheta
+	phi := 2 * math.Pi * rng.Float64()
+
+	// The point that the direction hits is worked out from the angle alpha
+	// between it and origin, seen from the center, rather than by tracing a
+	// ray, which loses too much precision when origin is far away.
+	distance := math.Sqrt(distanceSquared)
+	toSurface := distance*cosTheta - math.Sqrt(max(0, radiusSquared-distanceSquared*sinThetaSquared))
+	cosAlpha := (distanceSquared + radiusSquared - toSurface*toSurface) / (2 * distance * s.Radius)
+	sinAlpha := math.Sqrt(max(0, 1-cosAlpha*cosAlpha))
+	w := toCenter.Divide(distance)
+	tangent, bitangent := vec.Basis(w)
+	outwardNormal := tangent.Scale(-sinAlpha * math.Cos(phi)).
+		Add(bitangent.Scale(-sinAlpha * math.Sin(phi))).
+		Add(w.Scale(-cosAlpha))
+	point := s.Center.Add(outwardNormal.Scale(s.Radius))
+
+	record := NewHitRecord(Ray{Origin: origin, Direction: point.Subtract(origin)}, 1, outwardNormal, point, s.Material)
+	record.U, record.V = sphereTexCoords(outwardNormal)
+	return lightSample(record, 1/(2*math.Pi*oneMinusCosThetaMax)), true
+}
+
+func (s Sphere) PDF(origin Vec3, direction Vec3) float64 {
+	toCenter := s.Center.Subtract(origin)
+	distanceSquared := toCenter.LengthSquared()
+	radiusSquared := s.Radius * s.Radius
+	if distanceSquared <= radiusSquared {
+		hit, record := s.Hit(Ray{Origin: origin, Direction: direction}, 0, math.Inf(1), nil)
+		if !hit {
+			return 0
+		}
+		return areaPDF(record, 4*math.Pi*radiusSquared)
+	}
+	cosThetaMax := math.Sqrt(1 - radiusSquared/distanceSquared)
+	if direction.Dot(toCenter)/math.Sqrt(distanceSquared) < cosThetaMax {
+		// outside of the cone that the sphere fills
+		return 0
+	}
+	return 1 / (2 * math.Pi * radiusSquared / distanceSquared / (1 + cosThetaMax))
+}
+
+// Sample picks a point uniformly from the area of the quad.
+func (q Quad) Sample(origin Vec3, rng *rand.Rand) (LightSample, bool) {
+	alpha, beta := rng.Float64(), rng.Float64()
+	point := q.Q.Add(q.U.Scale(alpha)).Add(q.V.Scale(beta))
+	n := q.U.Cross(q.V)
+	area := n.Length()
+	record := NewHitRecord(Ray{Origin: origin, Direction: point.Subtract(origin)}, 1, n.Divide(area), point, q.Material)
+	record.U, record.V = alpha, beta
+	return areaLightSample(record, area)
+}
+
+func (q Quad) PDF(origin Vec3, direction Vec3) float64 {
+	hit, record := q.Hit(Ray{Origin: origin, Direction: direction}, 0, math.Inf(1), nil)
+	if !hit {
+		return 0
+	}
+	return areaPDF(record, q.U.Cross(q.V).Length())
+}
+
+// Sample picks a point uniformly from the area of the triangle.
+func (tri Triangle) Sample(origin Vec3, rng *rand.Rand) (LightSample, bool) {
+	// Folding a random point in the unit square into the triangle with a
+	// square root keeps the points evenly spread over it.
+	r, t := math.Sqrt(rng.Float64()), rng.Float64()
+	u, v := r*(1-t), r*t
+	point := tri.A.Add(tri.B.Subtract(tri.A).Scale(u)).Add(tri.C.Subtract(tri.A).Scale(v))
+	n := tri.B.Subtract(tri.A).Cross(tri.C.Subtract(tri.A))
+	area := n.Length() / 2
+	record := NewHitRecord(Ray{Origin: origin, Direction: point.Subtract(origin)}, 1, n.Divide(2*area), point, tri.Material)
+	record.U, record.V = tri.texCoords(u, v)
+	return areaLightSample(record, area)
+}
+
+func (tri Triangle) PDF(origin Vec3, direction Vec3) float64 {
+	hit, record := tri.Hit(Ray{Origin: origin, Direction: direction}, 0, math.Inf(1), nil)
+	if !hit {
+		return 0
+	}
+	// The density depends on how tilted the triangle itself is, not its
+	// smooth normal.
+	n := tri.B.Subtract(tri.A).Cross(tri.C.Subtract(tri.A))
+	record.Normal = n.UnitVector()
+	return areaPDF(record, n.Length()/2)
+}
+
+// areaLightSample is the sample of a light at record.HitPoint, which was picked
+// uniformly from the light's area as seen from record.Ray.Origin.
+func areaLightSample(record HitRecord, area float64) (LightSample, bool) {
+	pdf := areaPDF(record, area)
+	if pdf == 0 {
+		return LightSample{}, false
+	}
+	return lightSample(record, pdf), true
+}
+
+// areaPDF converts the probability density of picking record.HitPoint from a
+// light's area to the density per unit of solid angle as seen from
+// record.Ray.Origin. It's 0 if the light is edge on.
+func areaPDF(record HitRecord, area float64) float64 {
+	toPoint := record.HitPoint.Subtract(record.Ray.Origin)
+	distanceSquared := toPoint.LengthSquared()
+	cosine := math.Abs(record.Normal.Dot(toPoint)) / math.Sqrt(distanceSquared)
+	if cosine < 1e-9 {
+		return 0
+	}
+	// A patch of the light covers less solid angle the further away and more
+	// tilted it is.
+	return distanceSquared / (cosine * area)
+}
+
+// lightSample is the light given off at record.HitPoint towards
+// record.Ray.Origin, which was picked with the probability density pdf.
+func lightSample(record HitRecord, pdf float64) LightSample {
+	toPoint := record.HitPoint.Subtract(record.Ray.Origin)
+	distance := toPoint.Length()
+	var radiance Color
+	if emitter, ok := record.Material.(Emitter); ok {
+		radiance = emitter.Emitted(record)
+	}
+	return LightSample{Direction: toPoint.Divide(distance), Distance: distance, Radiance: radiance, PDF: pdf}
+}
+
+// directLight estimates the light that arrives at record.HitPoint straight
+// from one of lights, picked at random, and is scattered back along the ray by
+// bsdf. It's weighted against the chance that bsdf would have scattered a ray
+// into the light anyway, which Ray.Color weights the other way.
+func directLight(h Hittable, lights []Light, bsdf BSDF, record HitRecord, rng *rand.Rand, tMin float64) Color {
+	light := lights[rng.IntN(len(lights))]
+	sample, ok := light.Sample(record.HitPoint, rng)
+	if !ok || (!sample.Delta && sample.PDF <= 0) {
+		return black
+	}
+	scattered := bsdf.Eval(record, sample.Direction)
+	if scattered == black {
+		// don't bother with a shadow ray when nothing would be scattered
+		return black
+	}
+	shadow := Ray{record.HitPoint, sample.Direction, record.Ray.Time}
+	if hit, _ := h.Hit(shadow, tMin, sample.Distance-tMin, rng); hit {
+		return black
+	}
+	// Each light is only picked some of the time, which is made up for by
+	// dividing by the chance of picking it.
+	if sample.Delta {
+		// scattered rays can never find this light, so there's nothing to
+		// weigh it against
+		return Color{scattered.Vec.Hadamard(sample.Radiance.Vec).Scale(float64(len(lights)))}
+	}
+	pdf := sample.PDF / float64(len(lights))
+	weight := powerHeuristic(pdf, bsdf.PDF(record, sample.Direction))
+	return Color{scattered.Vec.Hadamard(sample.Radiance.Vec).Scale(weight / pdf)}
+}
+
+// distantLight is a Light that's infinitely far away, so rays that don't hit
+// anything can see it.
+type distantLight interface {
+	Light
+	// radiance returns the light that the light sends back along direction.
+	radiance(direction Vec3) Color
+}
+
+// powerHeuristic is how much to trust a sample that was picked with the
+// probability density pdf, when another way of picking it had the density
+// other. Whichever way was more likely to find the light gets most of the
+// weight, and the weights for the two add up to 1.
+func powerHeuristic(pdf, other float64) float64 {
+	a, b := pdf*pdf, other*other
+	if a+b == 0 {
+		return 0
+	}
+	return a / (a + b)
+}
+
+// PointLight shines equally in every direction from a single point. It can't
+// be seen, only the things it lights can.
+type PointLight struct {
+	Position Vec3
+	// Intensity is the light given off per unit of solid angle. The light
+	// that reaches a surface falls off with the square of its distance.
+	Intensity Color
+}
+
+func (p PointLight) Sample(origin Vec3, rng *rand.Rand) (LightSample, bool) {
+	return pointSample(origin, p.Position, p.Intensity)
+}
+
+// PDF is 0 because a ray that's scattered at random can never hit a point.
+func (p PointLight) PDF(origin Vec3, direction Vec3) float64 {
+	return 0
+}
+
+// pointSample is the light with the given intensity that arrives at origin
+// from position.
+func pointSample(origin, position Vec3, intensity Color) (LightSample, bool) {
+	toLight := position.Subtract(origin)
+	distanceSquared := toLight.LengthSquared()
+	if distanceSquared == 0 {
+		return LightSample{}, false
+	}
+	distance := math.Sqrt(distanceSquared)
+	return LightSample{
+		Direction: toLight.Divide(distance),
+		Distance:  distance,
+		Radiance:  Color{intensity.Vec.Divide(distanceSquared)},
+		Delta:     true,
+	}, true
+}
+
+// SpotLight is a PointLight that only shines in a cone around Direction.
+type SpotLight struct {
+	Position Vec3
+	// Direction runs from Position down the middle of the cone. Only its
+	// direction matters, not its length.
+	Direction Vec3
+	// Intensity is the light given off per unit of solid angle inside
+	// InnerAngle.
+	Intensity Color
+	// InnerAngle and OuterAngle are the degrees from Direction where the
+	// light starts to fade and where it's gone. The fade is smooth.
+	InnerAngle float64
+	OuterAngle float64
+}
+
+func (s SpotLight) Sample(origin Vec3, rng *rand.Rand) (LightSample, bool) {
+	sample, ok := pointSample(origin, s.Position, s.Intensity)
+	if !ok {
+		return LightSample{}, false
+	}
+	cosine := -sample.Direction.Dot(s.Direction.UnitVector())
+	sample.Radiance.Vec = sample.Radiance.Vec.Scale(s.falloff(cosine))
+	return sample, sample.Radiance != black
+}
+
+// falloff returns how much of the light shines at an angle from Direction
+// with the given cosine.
+func (s SpotLight) falloff(cosine float64) float64 {
+	cosInner := math.Cos(toRadians(s.InnerAngle))
+	cosOuter := math.Cos(toRadians(s.OuterAngle))
+	if cosine >= cosInner {
+		return 1
+	}
+	if cosine <= cosOuter {
+		return 0
+	}
+	t := (cosine - cosOuter) / (cosInner - cosOuter)
+	return t * t * (3 - 2*t)
+}
+
+// PDF is 0 just like a PointLight's. Narrowing the light to a cone doesn't
+// make its tip any easier to hit.
+func (s SpotLight) PDF(origin Vec3, direction Vec3) float64 {
+	return 0
+}
+
+// DirectionalLight is light from so far away, like the sun, that it shines the
+// same way everywhere.
+type DirectionalLight struct {
+	// Direction is the way the light travels, so a sun straight overhead
+	// is [0, -1, 0] or any longer vector along it.
+	Direction Vec3
+	// Irradiance is the light that falls on a surface facing the light.
+	Irradiance Color
+	// AngularDiameter is how many degrees across the light looks, which
+	// softens the edges of its shadows. The sun is about 0.5. If it's 0, the
+	// light comes from exactly one direction and can't be seen.
+	AngularDiameter float64
+}
+
+// oneMinusCosRadius is 1 - the cosine of the angle from the center of the
+// light to its edge.
+func (d DirectionalLight) oneMinusCosRadius() float64 {
+	// 1 - cos(x) = 2sin²(x/2) doesn't lose precision for small angles
+	sinQuarter := math.Sin(toRadians(d.AngularDiameter) / 4)
+	return 2 * sinQuarter * sinQuarter
+}
+
+func (d DirectionalLight) Sample(origin Vec3, rng *rand.Rand) (LightSample, bool) {
+	toLight := d.Direction.UnitVector().Scale(-1)
+	if d.AngularDiameter <= 0 {
+		return LightSample{Direction: toLight, Distance: math.Inf(1), Radiance: d.Irradiance, Delta: true}, true
+	}
+
+	oneMinusCos := d.oneMinusCosRadius()
+	cosTheta := 1 - rng.Float64()*oneMinusCos
+	sinTheta := math.Sqrt(max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * rng.Float64()
+	tangent, bitangent := vec.Basis(toLight)
+	direction := tangent.Scale(math.Cos(phi) * sinTheta).
+		Add(bitangent.Scale(math.Sin(phi) * sinTheta)).
+		Add(toLight.Scale(cosTheta))
+	return LightSample{
+		Direction: direction,
+		Distance:  math.Inf(1),
+		Radiance:  d.radiance(direction),
+		PDF:       1 / (2 * math.Pi * oneMinusCos),
+	}, true
+}
+
+func (d DirectionalLight) PDF(origin Vec3, direction Vec3) float64 {
+	if d.radiance(direction) == black {
+		return 0
+	}
+	return 1 / (2 * math.Pi * d.oneMinusCosRadius())
+}
+
+// radiance is spread evenly over the disk of the light, so that the light it
+// casts on a surface facing it adds up to Irradiance.
+func (d DirectionalLight) radiance(direction Vec3) Color {
+	if d.AngularDiameter <= 0 {
+		return black
+	}
+	oneMinusCos := d.oneMinusCosRadius()
+	toLight := d.Direction.UnitVector().Scale(-1)
+	if 1-direction.UnitVector().Dot(toLight) > oneMinusCos {
+		return black
+	}
+	// the integral of the cosine over the disk is π(1 - cos²)
+	cosRadius := 1 - oneMinusCos
+	return Color{d.Irradiance.Vec.Divide(math.Pi * oneMinusCos * (1 + cosRadius))}
+}
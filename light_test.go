@@ -0,0 +1,352 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestDirectLightingMatchesPathTracing(t *testing.T) {
+	// Gathering light from the lights directly should give the same answer as
+	// only finding them by chance, just with less noise.
+	world := World{
+		Plane{vec.New(0, 0, 0), vec.New(0, 1, 0), Lambertian{newColor(0.5, 0.5, 0.5)}},
+		Sphere{vec.New(0, 3, 0), 1, DiffuseLight{newColor(4, 4, 4)}},
+		Quad{vec.New(1, 2, -1), vec.New(2, 0, 0), vec.New(0, 0, 2), DiffuseLight{newColor(2, 1, 0.5)}},
+		// a lamp made of a mesh
+		NewBVH(World{
+			Triangle{A: vec.New(-3, 2, -1), B: vec.New(-2, 2, -1), C: vec.New(-2.5, 2, 1), Material: DiffuseLight{newColor(0.5, 1, 3)}},
+			Triangle{A: vec.New(-3, 2, -1), B: vec.New(-2.5, 2, 1), C: vec.New(-3.5, 2.5, 0), Material: DiffuseLight{newColor(0.5, 1, 3)}},
+		}),
+		// in the way of some of the light
+		Sphere{vec.New(0.8, 1, 0), 0.4, Lambertian{newColor(0.8, 0.8, 0.8)}},
+	}
+	world, lights := MarkLights(world)
+	if len(lights) != 4 {
+		t.Fatalf("expected to find 4 lights, got %d", len(lights))
+	}
+
+	const samples = 200000
+	rng := rand.New(rand.NewPCG(9, 10))
+	estimate := func(lights []Light) Color {
+		var sum Color
+		for range samples {
+			ray := Ray{Origin: vec.New(-3, 2, 3), Direction: vec.New(3.5, -2, -3.3)}
+			light, _ := ray.Color(world, black, lights, rng, 0.001, math.Inf(1), 50)
+			sum.Vec = sum.Vec.Add(light.Vec)
+		}
+		return Color{sum.Vec.Divide(samples)}
+	}
+	pathTraced := estimate(nil)
+	direct := estimate(lights)
+	if direct.Vec.Subtract(pathTraced.Vec).Length() > 0.03*pathTraced.Vec.Length() {
+		t.Errorf("direct lighting gave %v, but path tracing gave %v", direct, pathTraced)
+	}
+}
+
+func TestMarkLights(t *testing.T) {
+	glow := DiffuseLight{white}
+	world := World{
+		Sphere{vec.New(0, 0, 0), 1, Lambertian{white}},
+		Sphere{vec.New(0, 5, 0), 1, glow},
+		NewBox(vec.New(0, 0, 0), vec.New(1, 1, 1), glow),
+		NewTransformed(Sphere{vec.New(0, 0, 0), 1, glow}, vec.Translation(vec.New(3, 0, 0))),
+		NewBVH(World{
+			Triangle{A: vec.New(5, 0, 0), B: vec.New(6, 0, 0), C: vec.New(5, 0, 1), Material: glow},
+			Triangle{A: vec.New(5, 2, 0), B: vec.New(6, 2, 0), C: vec.New(5, 2, 1), Material: Lambertian{white}},
+		}),
+	}
+	marked, lights := MarkLights(world)
+	// the sphere, the six sides of the box, and the glowing triangle in the
+	// mesh, but not the transformed sphere
+	if len(lights) != 8 {
+		t.Errorf("expected 8 lights, got %d", len(lights))
+	}
+
+	// Lights are only counted once when they're hit, so hitting one that
+	// isn't in the list has to be obvious.
+	down := Ray{Origin: vec.New(0, 8, 0), Direction: vec.New(0, -1, 0)}
+	if _, record := marked[1].Hit(down, 0.001, math.Inf(1), nil); record.Light == nil {
+		t.Error("expected hitting a light that was marked to record it")
+	}
+	if _, record := marked[3].Hit(Ray{Origin: vec.New(3, 5, 0), Direction: vec.New(0, -1, 0)}, 0.001, math.Inf(1), nil); record.Light != nil {
+		t.Errorf("expected a transformed light not to be a Light, got %+v", record.Light)
+	}
+	meshDown := Ray{Origin: vec.New(5.2, 5, 0.2), Direction: vec.New(0, -1, 0)}
+	if _, record := marked[4].Hit(meshDown, 0.001, math.Inf(1), nil); record.Light != nil {
+		t.Errorf("expected the dull triangle in the mesh not to be a Light, got %+v", record.Light)
+	}
+	if _, record := marked[4].Hit(meshDown, 4, math.Inf(1), nil); record.Light == nil {
+		t.Error("expected hitting the glowing triangle in the mesh to record it")
+	}
+	if !emitsLight(world[3]) {
+		t.Error("expected the transformed light to be known to give off light")
+	}
+
+	// The world that was passed in, and anything already built from it, is
+	// left alone.
+	if _, ok := world[1].(Sphere); !ok {
+		t.Errorf("expected the original world to be unmarked, got %T", world[1])
+	}
+	if _, ok := world[2].(World)[0].(Quad); !ok {
+		t.Errorf("expected the original box to be unmarked, got %T", world[2].(World)[0])
+	}
+	if _, record := world[4].Hit(meshDown, 4, math.Inf(1), nil); record.Light != nil {
+		t.Errorf("expected the original mesh to be unmarked, got %+v", record.Light)
+	}
+	if _, record := NewBVH(marked).Hit(down, 0.001, math.Inf(1), nil); record.Light == nil {
+		t.Error("expected a BVH of the marked world to record hitting a light")
+	}
+}
+
+// taggedLight is a DiffuseLight that can't be compared with ==.
+type taggedLight struct {
+	DiffuseLight
+	tags []string
+}
+
+func TestLightsNeedNotBeComparable(t *testing.T) {
+	world := World{
+		Plane{vec.New(0, 0, 0), vec.New(0, 1, 0), Lambertian{newColor(0.5, 0.5, 0.5)}},
+		Sphere{vec.New(0, 3, 0), 1, taggedLight{DiffuseLight{white}, []string{"lamp"}}},
+	}
+	world, lights := MarkLights(world)
+	rng := rand.New(rand.NewPCG(39, 40))
+	for range 100 {
+		ray := Ray{Origin: vec.New(-3, 2, 3), Direction: vec.New(3.5, -2, -3.3)}
+		ray.Color(world, black, lights, rng, 0.001, math.Inf(1), 50)
+	}
+}
+
+// countedLight counts the times a Light is sampled.
+type countedLight struct {
+	Light
+	count *int
+}
+
+func (c countedLight) Sample(origin Vec3, rng *rand.Rand) (LightSample, bool) {
+	*c.count++
+	return c.Light.Sample(origin, rng)
+}
+
+func TestDeltaSurfacesDontSampleLights(t *testing.T) {
+	floors := []struct {
+		name     string
+		material Material
+		delta    bool
+	}{
+		{"mirror", Metal{Albedo: white}, true},
+		{"clear glass", Dielectric{RefractionIndex: 1.5}, true},
+		{"brushed metal", Metal{Albedo: white, Roughness: 0.3}, false},
+		{"frosted glass", Dielectric{RefractionIndex: 1.5, Roughness: 0.3}, false},
+	}
+	for _, floor := range floors {
+		world := World{Plane{vec.New(0, 0, 0), vec.New(0, 1, 0), floor.material}}
+		var count int
+		lights := []Light{countedLight{PointLight{vec.New(0, 3, 0), white}, &count}}
+		rng := rand.New(rand.NewPCG(37, 38))
+		ray := Ray{Origin: vec.New(-1, 1, 0), Direction: vec.New(1, -1, 0)}
+		ray.Color(world, black, lights, rng, 0.001, math.Inf(1), 1)
+		if floor.delta && count > 0 {
+			t.Errorf("%s: expected the light not to be sampled, but it was sampled %d times", floor.name, count)
+		}
+		if !floor.delta && count == 0 {
+			t.Errorf("%s: expected the light to be sampled", floor.name)
+		}
+	}
+}
+
+func TestPDFsMatchScatterRate(t *testing.T) {
+	// Averaging a PDF over directions picked uniformly from the whole sphere,
+	// times the sphere's 4π steradians, integrates it.
+	rng := rand.New(rand.NewPCG(11, 12))
+	const samples = 400000
+	integrate := func(pdf func(direction Vec3) float64) float64 {
+		var sum float64
+		for range samples {
+			sum += pdf(vec.RandomUnit(rng))
+		}
+		return 4 * math.Pi * sum / samples
+	}
+
+	origin := vec.New(0.3, 0.5, -0.2)
+	lights := map[string]Light{
+		"far sphere":    Sphere{vec.New(2, 3, 1), 1.5, DiffuseLight{white}},
+		"inside sphere": Sphere{vec.New(0, 0, 0), 2, DiffuseLight{white}},
+		"quad":          Quad{vec.New(-1, 2, -1), vec.New(2, 0, 0), vec.New(0, 0.5, 2), DiffuseLight{white}},
+	}
+	for name, light := range lights {
+		if total := integrate(func(d Vec3) float64 { return light.PDF(origin, d) }); math.Abs(total-1) > 0.02 {
+			t.Errorf("%s: PDF integrates to %v", name, total)
+		}
+	}
+
+	record := NewHitRecord(Ray{Origin: vec.New(-1, 1, 0), Direction: vec.New(1, -1, 0.2)}, 1, vec.New(0, 1, 0), vec.New(0, 0, 0.2), nil)
+	materials := map[string]BSDF{
+		"lambertian":       Lambertian{white},
+		"isotropic":        Isotropic{white},
+		"metal":            Metal{Albedo: white, Roughness: 0.3},
+		"rough metal":      Metal{Albedo: white, Roughness: 1},
+		"principled":       Principled{BaseColor: white, Roughness: 0.5, Specular: 0.5, Clearcoat: 1, ClearcoatRoughness: 0.2},
+		"frosted glass":    Principled{BaseColor: white, Roughness: 0.5, Specular: 0.5, Transmission: 1},
+		"rough dielectric": Dielectric{RefractionIndex: 1.5, Roughness: 0.5},
+	}
+	for name, material := range materials {
+		// Rough metal loses the rays it would scatter into itself, so its
+		// PDF only adds up to the fraction of rays that get out.
+		const samples = 100000
+		var scattered int
+		for range samples {
+			if ok, _, _ := material.(Material).Scatter(record, rng); ok {
+				scattered++
+			}
+		}
+		want := float64(scattered) / samples
+		if total := integrate(func(d Vec3) float64 { return material.PDF(record, d) }); math.Abs(total-want) > 0.02 {
+			t.Errorf("%s: PDF integrates to %v, but %v of rays scatter", name, total, want)
+		}
+	}
+}
+
+func TestTriangleLight(t *testing.T) {
+	tri := Triangle{A: vec.New(-1, 2, -1), B: vec.New(1, 2, -1), C: vec.New(0, 2.5, 1), Material: DiffuseLight{white}}
+	origin := vec.New(0.3, 0.5, -0.2)
+	rng := rand.New(rand.NewPCG(41, 42))
+
+	// The PDF integrates to 1 over the sphere of directions.
+	const samples = 400000
+	var sum float64
+	for range samples {
+		sum += tri.PDF(origin, vec.RandomUnit(rng))
+	}
+	if total := 4 * math.Pi * sum / samples; math.Abs(total-1) > 0.02 {
+		t.Errorf("PDF integrates to %v", total)
+	}
+
+	// Samples land on the triangle, and agree with the PDF.
+	for range 100 {
+		sample, ok := tri.Sample(origin, rng)
+		if !ok {
+			t.Fatal("expected a sample")
+		}
+		hit, record := tri.Hit(Ray{Origin: origin, Direction: sample.Direction}, 0.001, math.Inf(1), nil)
+		if !hit || math.Abs(record.T*sample.Direction.Length()-sample.Distance) > 1e-9 {
+			t.Fatalf("expected the sample %+v to be on the triangle", sample)
+		}
+		if pdf := tri.PDF(origin, sample.Direction); math.Abs(pdf-sample.PDF) > 1e-9*pdf {
+			t.Fatalf("sample has a PDF of %v, but PDF gives %v", sample.PDF, pdf)
+		}
+	}
+}
+
+func TestMultipleImportanceSamplingMatchesPathTracing(t *testing.T) {
+	floors := map[string]Material{
+		"shiny":  Metal{Albedo: newColor(0.9, 0.8, 0.7), Roughness: 0.4},
+		"coated": Principled{BaseColor: newColor(0.2, 0.4, 0.8), Roughness: 0.5, Specular: 0.5, Clearcoat: 1, ClearcoatRoughness: 0.1},
+	}
+	for name, floor := range floors {
+		// a floor under a big light and a small one
+		world := World{
+			Plane{vec.New(0, 0, 0), vec.New(0, 1, 0), floor},
+			Quad{vec.New(-3, 3, -3), vec.New(6, 0, 0), vec.New(0, 0, 6), DiffuseLight{newColor(1, 1, 1)}},
+			Sphere{vec.New(1, 1, 0), 0.3, DiffuseLight{newColor(10, 10, 10)}},
+		}
+		world, lights := MarkLights(world)
+
+		const samples = 200000
+		rng := rand.New(rand.NewPCG(13, 14))
+		estimate := func(lights []Light) Color {
+			var sum Color
+			for range samples {
+				ray := Ray{Origin: vec.New(-2, 1.5, 0), Direction: vec.New(2, -1.5, 0.3)}
+				light, _ := ray.Color(world, black, lights, rng, 0.001, math.Inf(1), 50)
+				sum.Vec = sum.Vec.Add(light.Vec)
+			}
+			return Color{sum.Vec.Divide(samples)}
+		}
+		pathTraced := estimate(nil)
+		mis := estimate(lights)
+		if mis.Vec.Subtract(pathTraced.Vec).Length() > 0.03*pathTraced.Vec.Length() {
+			t.Errorf("%s: multiple importance sampling gave %v, but path tracing gave %v", name, mis, pathTraced)
+		}
+	}
+}
+
+func TestSampleFarAwaySphere(t *testing.T) {
+	// A floor seen at a grazing angle gets hit very far away, and the lights
+	// still have to be sampled from there.
+	sphere := Sphere{vec.New(-4, 1.5, 3), 0.5, DiffuseLight{white}}
+	origin := vec.New(5.2e7, 0, -1.7e8)
+	rng := rand.New(rand.NewPCG(15, 16))
+	for range 1000 {
+		sample, ok := sphere.Sample(origin, rng)
+		if !ok {
+			t.Fatal("expected to be able to see the sphere")
+		}
+		if pdf := sphere.PDF(origin, sample.Direction); math.Abs(pdf-sample.PDF) > 1e-6*sample.PDF {
+			t.Fatalf("sampled with a PDF of %v, but PDF is %v", sample.PDF, pdf)
+		}
+	}
+}
+
+func TestPunctualLights(t *testing.T) {
+	// Looking straight down at a white floor one unit below a light, the
+	// floor reflects albedo/π of the light that falls on it.
+	floor := Plane{vec.New(0, 0, 0), vec.New(0, 1, 0), Lambertian{newColor(0.5, 0.5, 0.5)}}
+	spot := func(x float64) Ray { return Ray{Origin: vec.New(x, 5, 0), Direction: vec.New(0, -1, 0)} }
+	halfway := (math.Cos(toRadians(30)) + math.Cos(toRadians(60))) / 2
+	tests := []struct {
+		name  string
+		light Light
+		ray   Ray
+		want  float64
+	}{
+		{"point", PointLight{vec.New(0, 1, 0), newColor(2, 2, 2)}, spot(0), 1 / math.Pi},
+		{"point at an angle", PointLight{vec.New(0, 1, 0), newColor(2, 2, 2)}, spot(1), 1 / math.Pi / (2 * math.Sqrt2)},
+		{"spot inside", SpotLight{vec.New(0, 1, 0), vec.New(0, -1, 0), newColor(2, 2, 2), 30, 60}, spot(0.5), 1 / math.Pi / math.Pow(1.25, 1.5)},
+		{"spot outside", SpotLight{vec.New(0, 1, 0), vec.New(0, -1, 0), newColor(2, 2, 2), 30, 60}, spot(2), 0},
+		// halfway between the cosines of 30 and 60 degrees is a smoothstep of 0.5
+		{"spot fading", SpotLight{vec.New(0, 1, 0), vec.New(0, -1, 0), newColor(2, 2, 2), 30, 60}, spot(math.Tan(math.Acos(halfway))), 0.5 * math.Pow(halfway, 3) / math.Pi},
+		{"sun", DirectionalLight{vec.New(0, -1, 0), newColor(2, 2, 2), 0}, spot(0), 1 / math.Pi},
+		{"sun at an angle", DirectionalLight{vec.New(1, -1, 0), newColor(2, 2, 2), 0}, spot(0), 1 / math.Pi / math.Sqrt2},
+	}
+	rng := rand.New(rand.NewPCG(17, 18))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// one bounce is enough for the light from the floor
+			light, _ := test.ray.Color(floor, black, []Light{test.light}, rng, 0.001, math.Inf(1), 1)
+			if math.Abs(light.R()-test.want) > 1e-9 {
+				t.Errorf("expected %v, got %v", test.want, light.R())
+			}
+		})
+	}
+}
+
+func TestSunDisk(t *testing.T) {
+	floor := Plane{vec.New(0, 0, 0), vec.New(0, 1, 0), Lambertian{newColor(0.5, 0.5, 0.5)}}
+	sun := DirectionalLight{vec.New(0, -1, 0), newColor(2, 2, 2), 20}
+	rng := rand.New(rand.NewPCG(19, 20))
+
+	// A big sun lights a floor facing it about as much as a point of a sun
+	// does. Scattered rays that head for the disk find it too, and it's all
+	// weighted so that nothing is counted twice.
+	const samples = 100000
+	var sum float64
+	for range samples {
+		light, _ := Ray{Origin: vec.New(0, 5, 0), Direction: vec.New(0, -1, 0)}.Color(floor, black, []Light{sun}, rng, 0.001, math.Inf(1), 2)
+		sum += light.R()
+	}
+	if mean := sum / samples; math.Abs(mean-1/math.Pi) > 0.01/math.Pi {
+		t.Errorf("expected the floor to be about %v, got %v", 1/math.Pi, mean)
+	}
+
+	// and the disk can be seen
+	if light, _ := (Ray{Origin: vec.New(0, 0, 0), Direction: vec.New(0.1, 1, 0)}).Color(World{}, black, []Light{sun}, rng, 0.001, math.Inf(1), 1); light != sun.radiance(vec.New(0, 1, 0)) {
+		t.Errorf("expected to see the sun, got %v", light)
+	}
+	if light, _ := (Ray{Origin: vec.New(0, 0, 0), Direction: vec.New(1, 1, 0)}).Color(World{}, black, []Light{sun}, rng, 0.001, math.Inf(1), 1); light != black {
+		t.Errorf("expected to miss the sun, got %v", light)
+	}
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
 	"log"
 	"math"
-	"math/rand"
+	"math/rand/v2"
 	"os"
+	"os/signal"
 
 	"github.com/Anthony-Fiddes/raytracing-1w/vec"
 )
 
 type Vec3 = vec.Vec3
 
-// X, Y, and Z represent red, green, and blue values. They are floats between 0 and 1
+// X, Y, and Z represent linear red, green, and blue values. They are floats >=
+// 0. 1 is the brightest value that can be displayed without tone mapping, but
+// light sources and the like can be brighter.
 type Color struct{ Vec Vec3 }
 
 var (
@@ -28,29 +35,16 @@ func newColor(r, g, b float64) Color {
 	return Color{Vec3{X: r, Y: g, Z: b}}
 }
 
+// isValidColor reports whether f can be a color component. Components can be
+// brighter than 1 (e.g. light sources) but never negative.
 func isValidColor(f float64) bool {
-	if f < 0 || f > 1 {
-		return false
-	}
-	return true
+	return f >= 0 && !math.IsNaN(f)
 }
 
 func (c Color) String() string {
 	return fmt.Sprintf("Color{Red: %v, Green: %v, Blue: %v}", c.R(), c.G(), c.B())
 }
 
-func (c Color) assertValid() {
-	if !isValidColor(c.R()) {
-		log.Panicf("%v has invalid red value %g. It must be between 0 and 1", c, c.R())
-	}
-	if !isValidColor(c.G()) {
-		log.Panicf("%v has invalid green value %g. It must be between 0 and 1", c, c.G())
-	}
-	if !isValidColor(c.B()) {
-		log.Panicf("%v has invalid blue value %g. It must be between 0 and 1", c, c.B())
-	}
-}
-
 func (c Color) R() float64 {
 	return c.Vec.X
 }
@@ -77,6 +71,13 @@ type HitRecord struct {
 	HitPoint Vec3
 	// Material of the hit geometry
 	Material Material
+	// U and V are texture coordinates of the hit point on the surface of the
+	// geometry. They're left at zero by geometry that doesn't have any.
+	U float64
+	V float64
+	// Light is the object that was hit if MarkLights marked it as a Light,
+	// so that light gathered from it directly isn't counted again.
+	Light Light
 }
 
 // outwardNormal is a normal pointing out of the hit geometry. It must be a unit
@@ -102,34 +103,87 @@ func NewHitRecord(ray Ray, t float64, outwardNormal Vec3, hitPoint Vec3, mat Mat
 		)
 	}
 
-	return HitRecord{ray, t, normal, exterior, hitPoint, mat}
+	return HitRecord{
+		Ray: ray, T: t, Normal: normal, Exterior: exterior,
+		HitPoint: hitPoint, Material: mat,
+	}
 }
 
 type Material interface {
 	// Scatter returns whether the material scatters the ray and details about
 	// the new ray. If scattered is false, the ray was absorbed and scatteredRay and
-	// attenuation should be ignored.
-	Scatter(record HitRecord) (scattered bool, scatteredRay Ray, attenuation Color)
+	// attenuation should be ignored. Any randomness must come from rng.
+	Scatter(record HitRecord, rng *rand.Rand) (scattered bool, scatteredRay Ray, attenuation Color)
+}
+
+// Emitter is implemented by materials that give off light.
+type Emitter interface {
+	// Emitted returns the light given off by the material at the hit point.
+	Emitted(record HitRecord) Color
+}
+
+// BSDF is implemented by materials whose scattering can be worked out for any
+// pair of directions, which lets light be gathered from lights directly.
+type BSDF interface {
+	// Eval returns how much of the light arriving from direction, a unit
+	// vector, is scattered back along record.Ray. It includes the cosine
+	// term, so it's what Scatter would attenuate a ray sent in direction by,
+	// times the PDF of sending it there.
+	Eval(record HitRecord, direction Vec3) Color
+	// PDF returns the probability density, per unit of solid angle, that
+	// Scatter sends a ray in direction, a unit vector. It's 0 everywhere for
+	// a material that only scatters in exact directions, like a perfect
+	// mirror, since those directions can't be aimed at.
+	PDF(record HitRecord, direction Vec3) float64
+}
+
+// deltaBSDF is implemented by BSDFs that only scatter in exact directions at
+// some hits. Eval is 0 there, so gathering light directly would be wasted.
+type deltaBSDF interface {
+	BSDF
+	delta(record HitRecord) bool
 }
 
 type Lambertian struct {
-	Albedo Color
+	Albedo Texture
 }
 
-func (l Lambertian) Scatter(record HitRecord) (scattered bool, scatteredRay Ray, attenuation Color) {
-	scatterDirection := record.Normal.Add(vec.RandomUnit())
+func (l Lambertian) Scatter(record HitRecord, rng *rand.Rand) (scattered bool, scatteredRay Ray, attenuation Color) {
+	scatterDirection := record.Normal.Add(vec.RandomUnit(rng))
 	if vec.IsNearZero(scatterDirection) {
 		scatterDirection = record.Normal
 	}
-	newRay := Ray{record.HitPoint, scatterDirection}
-	return true, newRay, l.Albedo
+	newRay := Ray{record.HitPoint, scatterDirection, record.Ray.Time}
+	return true, newRay, l.Albedo.Value(record.U, record.V, record.HitPoint)
 }
 
+func (l Lambertian) Eval(record HitRecord, direction Vec3) Color {
+	cosine := record.Normal.Dot(direction)
+	if cosine <= 0 {
+		return black
+	}
+	albedo := l.Albedo.Value(record.U, record.V, record.HitPoint)
+	return Color{albedo.Vec.Scale(cosine / math.Pi)}
+}
+
+// PDF is cosine weighted, since a random unit vector added to the normal
+// points that way.
+func (l Lambertian) PDF(record HitRecord, direction Vec3) float64 {
+	return max(record.Normal.Dot(direction), 0) / math.Pi
+}
+
+// Metal is a conductor whose surface is made of tiny mirrors, or microfacets,
+// that face in directions following the GGX distribution.
 type Metal struct {
-	Albedo Color
-	// Fuzz is a proportion that determines how much the direction of reflected
-	// rays might vary from a theoretically perfect reflection.
-	Fuzz float64
+	Albedo Texture
+	// Roughness is in the range [0,1]. 0 is a perfect mirror, and 1 is about
+	// as rough as metal gets.
+	Roughness float64
+	// IOR is the complex index of refraction that the Fresnel reflectance is
+	// worked out from, which Albedo tints. If it's nil, Albedo is the
+	// reflectance looking straight at the surface and Schlick's approximation
+	// brightens it toward grazing angles.
+	IOR *ComplexIOR
 }
 
 func reflect(direction Vec3, normal Vec3) Vec3 {
@@ -137,17 +191,86 @@ func reflect(direction Vec3, normal Vec3) Vec3 {
 	return direction.Subtract(b.Scale(2))
 }
 
-func (m Metal) Scatter(record HitRecord) (scattered bool, scatteredRay Ray, attenuation Color) {
-	if m.Fuzz > 1 || m.Fuzz < 0 {
-		log.Panicf("fuzz must be in the range [0,1]")
+// fresnel returns how much light the metal reflects off a facet that it
+// arrives at with the given cosine.
+func (m Metal) fresnel(record HitRecord, cosine float64) Color {
+	albedo := m.Albedo.Value(record.U, record.V, record.HitPoint)
+	if m.IOR == nil {
+		return fresnelSchlick(albedo, cosine)
+	}
+	return Color{albedo.Vec.Hadamard(m.IOR.Fresnel(cosine).Vec)}
+}
+
+func (m Metal) Scatter(record HitRecord, rng *rand.Rand) (scattered bool, scatteredRay Ray, attenuation Color) {
+	if m.Roughness > 1 || m.Roughness < 0 {
+		log.Panicf("roughness must be in the range [0,1]")
+	}
+	unitDirection := record.Ray.Direction.UnitVector()
+	if m.Roughness == 0 {
+		scatterDirection := reflect(unitDirection, record.Normal)
+		newRay := Ray{record.HitPoint, scatterDirection, record.Ray.Time}
+		return true, newRay, m.fresnel(record, -unitDirection.Dot(record.Normal))
+	}
+
+	frame := newShadingFrame(record.Normal)
+	wo := frame.toLocal(unitDirection.Scale(-1))
+	if wo.Z <= 0 {
+		return false, Ray{}, Color{}
 	}
-	scatterDirection := reflect(record.Ray.Direction, record.Normal).UnitVector()
-	scatterDirection = scatterDirection.Add(vec.RandomUnit().Scale(m.Fuzz))
-	if scatterDirection.Dot(record.Normal) <= 0 {
+	g := newGGX(m.Roughness)
+	h := g.sampleVisibleNormal(wo, rng)
+	wi := reflect(wo.Scale(-1), h)
+	if wi.Z <= 0 {
+		// The ray would have to bounce between facets to get out, which this
+		// model doesn't follow, so a little light is lost on rough metal.
 		return false, Ray{}, Color{}
 	}
-	newRay := Ray{record.HitPoint, scatterDirection}
-	return true, newRay, m.Albedo
+	// Eval over PDF is short because sampling visible normals cancels most of
+	// it out.
+	weight := g.g2(wo, wi) / g.g1(wo)
+	attenuation = Color{m.fresnel(record, wo.Dot(h)).Vec.Scale(weight)}
+	newRay := Ray{record.HitPoint, frame.toWorld(wi), record.Ray.Time}
+	return true, newRay, attenuation
+}
+
+// Eval is the Cook-Torrance BRDF, D·G·F / (4 cosθo cosθi), times cosθi.
+func (m Metal) Eval(record HitRecord, direction Vec3) Color {
+	if m.Roughness <= 0 {
+		return black
+	}
+	frame := newShadingFrame(record.Normal)
+	wo := frame.toLocal(record.Ray.Direction.UnitVector().Scale(-1))
+	wi := frame.toLocal(direction)
+	if wo.Z <= 0 || wi.Z <= 0 {
+		return black
+	}
+	h := wo.Add(wi)
+	if vec.IsNearZero(h) {
+		return black
+	}
+	h = h.UnitVector()
+	g := newGGX(m.Roughness)
+	f := m.fresnel(record, wo.Dot(h))
+	return Color{f.Vec.Scale(g.d(h) * g.g2(wo, wi) / (4 * wo.Z))}
+}
+
+// PDF is 0 for a perfect mirror, since its one direction can't be aimed at.
+func (m Metal) PDF(record HitRecord, direction Vec3) float64 {
+	if m.Roughness <= 0 {
+		return 0
+	}
+	frame := newShadingFrame(record.Normal)
+	wo := frame.toLocal(record.Ray.Direction.UnitVector().Scale(-1))
+	wi := frame.toLocal(direction)
+	if wi.Z <= 0 {
+		return 0
+	}
+	return newGGX(m.Roughness).reflectionPDF(wo, wi)
+}
+
+// delta is true for a perfect mirror.
+func (m Metal) delta(record HitRecord) bool {
+	return m.Roughness <= 0
 }
 
 type Dielectric struct {
@@ -155,6 +278,14 @@ type Dielectric struct {
 	// use the ratio of the materials' refractive index to that of the
 	// surrounding medium.
 	RefractionIndex float64
+	// Roughness is in the range [0,1]. 0 is smooth, clear glass, and anything
+	// more is frosted by microfacets that follow the GGX distribution.
+	Roughness float64
+	// Absorption is how quickly the inside of the object absorbs each color
+	// of light. Following the Beer-Lambert law, light that goes a distance d
+	// through it is scaled by e^(-Absorption·d), so black is clear. It's only
+	// applied to light that goes between the object's own surfaces.
+	Absorption Color
 }
 
 func refract(direction Vec3, normal Vec3, refractionIndex float64) Vec3 {
@@ -172,7 +303,27 @@ func reflectanceProbability(cosine float64, refractionIndex float64) float64 {
 	return r0 + (1-r0)*math.Pow(1-cosine, 5)
 }
 
-func (d Dielectric) Scatter(record HitRecord) (scattered bool, scatteredRay Ray, attenuation Color) {
+func (d Dielectric) Scatter(record HitRecord, rng *rand.Rand) (scattered bool, scatteredRay Ray, attenuation Color) {
+	if d.Roughness > 1 || d.Roughness < 0 {
+		log.Panicf("roughness must be in the range [0,1]")
+	}
+	if d.Roughness > 0 {
+		frame := newShadingFrame(record.Normal)
+		wo := frame.toLocal(record.Ray.Direction.UnitVector().Scale(-1))
+		boundary := d.boundary(record)
+		wi, ok := boundary.sample(wo, rng)
+		if !ok {
+			return false, Ray{}, Color{}
+		}
+		pdf := boundary.pdf(wo, wi)
+		if pdf <= 0 {
+			return false, Ray{}, Color{}
+		}
+		attenuation = Color{d.absorbed(record).Vec.Scale(boundary.eval(wo, wi) / pdf)}
+		newRay := Ray{record.HitPoint, frame.toWorld(wi), record.Ray.Time}
+		return true, newRay, attenuation
+	}
+
 	refractionIndex := d.RefractionIndex
 	if record.Exterior {
 		refractionIndex = 1. / refractionIndex
@@ -182,7 +333,7 @@ func (d Dielectric) Scatter(record HitRecord) (scattered bool, scatteredRay Ray,
 	sinTheta := math.Sqrt(1. - (cosTheta * cosTheta))
 	canRefract := refractionIndex*sinTheta <= 1.
 	var scatterDirection Vec3
-	if canRefract && rand.Float64() > reflectanceProbability(cosTheta, refractionIndex) {
+	if canRefract && rng.Float64() > reflectanceProbability(cosTheta, refractionIndex) {
 		scatterDirection = refract(
 			unitDirection,
 			record.Normal,
@@ -194,8 +345,73 @@ func (d Dielectric) Scatter(record HitRecord) (scattered bool, scatteredRay Ray,
 			record.Normal,
 		)
 	}
-	newRay := Ray{record.HitPoint, scatterDirection}
-	return true, newRay, white
+	newRay := Ray{record.HitPoint, scatterDirection, record.Ray.Time}
+	return true, newRay, d.absorbed(record)
+}
+
+// boundary is the rough surface that record.Ray arrives at.
+func (d Dielectric) boundary(record HitRecord) roughDielectric {
+	eta := d.RefractionIndex
+	if !record.Exterior {
+		eta = 1 / eta
+	}
+	return roughDielectric{newGGX(d.Roughness), eta}
+}
+
+// absorbed returns how much of the light that travels along record.Ray
+// makes it to the hit point. A ray that hits the inside of the surface must
+// have come through the object, so some of it was absorbed on the way.
+func (d Dielectric) absorbed(record HitRecord) Color {
+	if record.Exterior || d.Absorption == black {
+		return white
+	}
+	distance := record.T * record.Ray.Direction.Length()
+	return newColor(
+		math.Exp(-d.Absorption.R()*distance),
+		math.Exp(-d.Absorption.G()*distance),
+		math.Exp(-d.Absorption.B()*distance),
+	)
+}
+
+// Eval is 0 for smooth glass, whose directions can't be aimed at.
+func (d Dielectric) Eval(record HitRecord, direction Vec3) Color {
+	if d.Roughness <= 0 {
+		return black
+	}
+	frame := newShadingFrame(record.Normal)
+	wo := frame.toLocal(record.Ray.Direction.UnitVector().Scale(-1))
+	value := d.boundary(record).eval(wo, frame.toLocal(direction))
+	return Color{d.absorbed(record).Vec.Scale(value)}
+}
+
+func (d Dielectric) PDF(record HitRecord, direction Vec3) float64 {
+	if d.Roughness <= 0 {
+		return 0
+	}
+	frame := newShadingFrame(record.Normal)
+	wo := frame.toLocal(record.Ray.Direction.UnitVector().Scale(-1))
+	return d.boundary(record).pdf(wo, frame.toLocal(direction))
+}
+
+// delta is true for smooth glass, which only reflects and refracts exactly.
+func (d Dielectric) delta(record HitRecord) bool {
+	return d.Roughness <= 0
+}
+
+// DiffuseLight is a material that emits the same light in every direction and
+// doesn't reflect any.
+type DiffuseLight struct {
+	// Emit is the color of the light. Its components can be greater than 1 to
+	// make a brighter light.
+	Emit Color
+}
+
+func (d DiffuseLight) Scatter(record HitRecord, rng *rand.Rand) (scattered bool, scatteredRay Ray, attenuation Color) {
+	return false, Ray{}, Color{}
+}
+
+func (d DiffuseLight) Emitted(record HitRecord) Color {
+	return d.Emit
 }
 
 type Sphere struct {
@@ -204,7 +420,7 @@ type Sphere struct {
 	Material Material
 }
 
-func (s Sphere) Hit(ray Ray, tMin float64, tMax float64) (bool, HitRecord) {
+func (s Sphere) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
 	if s.Radius < 0 {
 		log.Panicf("Sphere radius cannot be negative")
 	}
@@ -238,18 +454,34 @@ func (s Sphere) Hit(ray Ray, tMin float64, tMax float64) (bool, HitRecord) {
 	d := ray.Direction
 	Z := s.Center.Subtract(ray.Origin)
 	a := d.Dot(d)
-	// TODO: There's an optimization we can do by factoring out -2 from b.
-	b := d.Dot(Z) * -2.
+	// Factoring -2 out of b leaves h, and the roots become
+	// (h ± sqrt(h^2 - ac)) / a.
+	h := d.Dot(Z)
 	c := Z.Dot(Z) - (s.Radius * s.Radius)
-	discriminant := b*b - 4*a*c
+	// h^2 - ac cancels out to garbage when the ray starts far from the
+	// sphere, so the discriminant is worked out from how close the ray comes
+	// to the center instead. It's the same thing: a(r^2 - |Z - (h/a)d|^2).
+	closest := Z.Subtract(d.Scale(h / a))
+	discriminant := a * (s.Radius*s.Radius - closest.Dot(closest))
 	if discriminant < 0 {
 		return false, HitRecord{}
 	}
 
-	root := (-b - math.Sqrt(discriminant)) / (2. * a)
+	// One root is q/a. The other is c/q, which is the same as
+	// (h ∓ sqrt(discriminant)) / a without cancelling out when the ray starts
+	// close to the sphere.
+	q := h + math.Copysign(math.Sqrt(discriminant), h)
+	if q == 0 {
+		return false, HitRecord{}
+	}
+	near, far := c/q, q/a
+	if near > far {
+		near, far = far, near
+	}
+	root := near
 	if root <= tMin || tMax <= root {
 		// try the other possible root
-		root = (-b + math.Sqrt(discriminant)) / (2. * a)
+		root = far
 		if root <= tMin || tMax <= root {
 			// still out of the acceptable range
 			return false, HitRecord{}
@@ -257,12 +489,55 @@ func (s Sphere) Hit(ray Ray, tMin float64, tMax float64) (bool, HitRecord) {
 	}
 	hitPoint := ray.At(root)
 	outwardNormal := hitPoint.Subtract(s.Center).Divide(s.Radius)
-	return true, NewHitRecord(ray, root, outwardNormal, hitPoint, s.Material)
+	record := NewHitRecord(ray, root, outwardNormal, hitPoint, s.Material)
+	record.U, record.V = sphereTexCoords(outwardNormal)
+	return true, record
+}
+
+// sphereTexCoords maps a point p on the unit sphere to texture coordinates. U
+// goes around the sphere from -X through +Z, +X, and -Z back to -X, and V goes
+// from -Y to +Y, so an image wraps around the sphere like a map of the earth.
+func sphereTexCoords(p Vec3) (u, v float64) {
+	theta := math.Acos(max(min(-p.Y, 1), -1))
+	phi := math.Atan2(-p.Z, p.X) + math.Pi
+	return phi / (2 * math.Pi), theta / math.Pi
+}
+
+func (s Sphere) BoundingBox() AABB {
+	r := math.Abs(s.Radius)
+	radiusVec := vec.New(r, r, r)
+	return AABB{s.Center.Subtract(radiusVec), s.Center.Add(radiusVec)}
+}
+
+// MovingSphere is a sphere that moves in a straight line from Center0 at time
+// 0 to Center1 at time 1. It sits still at Center0 before that and at Center1
+// after.
+type MovingSphere struct {
+	Center0  Vec3
+	Center1  Vec3
+	Radius   float64
+	Material Material
+}
+
+// Center returns where the center of the sphere is at time t.
+func (s MovingSphere) Center(t float64) Vec3 {
+	t = min(max(t, 0), 1)
+	return s.Center0.Add(s.Center1.Subtract(s.Center0).Scale(t))
+}
+
+func (s MovingSphere) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	return Sphere{s.Center(ray.Time), s.Radius, s.Material}.Hit(ray, tMin, tMax, rng)
+}
+
+func (s MovingSphere) BoundingBox() AABB {
+	start := Sphere{s.Center0, s.Radius, s.Material}.BoundingBox()
+	end := Sphere{s.Center1, s.Radius, s.Material}.BoundingBox()
+	return start.Union(end)
 }
 
 type World []Hittable
 
-func (w World) Hit(ray Ray, tMin float64, tMax float64) (bool, HitRecord) {
+func (w World) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
 	hitAnything := false
 	closest := tMax
 	var closestRecord HitRecord
@@ -270,7 +545,7 @@ func (w World) Hit(ray Ray, tMin float64, tMax float64) (bool, HitRecord) {
 		if object == nil {
 			log.Panicf("how hard is it to not add a nil value to world?")
 		}
-		if hit, record := object.Hit(ray, tMin, closest); hit {
+		if hit, record := object.Hit(ray, tMin, closest, rng); hit {
 			closest = record.T
 			closestRecord = record
 			hitAnything = true
@@ -279,28 +554,37 @@ func (w World) Hit(ray Ray, tMin float64, tMax float64) (bool, HitRecord) {
 	return hitAnything, closestRecord
 }
 
-func renderRandomSpheres(opts CameraOpts) {
+func (w World) BoundingBox() AABB {
+	box := emptyAABB
+	for _, object := range w {
+		box = box.Union(object.BoundingBox())
+	}
+	return box
+}
+
+func renderRandomSpheres(ctx context.Context, opts CameraOpts) (image.Image, error) {
+	rng := rand.New(rand.NewPCG(opts.Seed, 0))
 	world := make(World, 0)
 	boundary := vec.New(4, 0.2, 0)
-	glassMat := &Dielectric{1.5}
+	glassMat := &Dielectric{RefractionIndex: 1.5}
 	for a := -11; a < 11; a++ {
 		for b := -11; b < 11; b++ {
-			chooseMat := rand.Float64()
-			center := vec.New(float64(a)+0.9*rand.Float64(), 0.2, float64(b)+0.9*rand.Float64())
+			chooseMat := rng.Float64()
+			center := vec.New(float64(a)+0.9*rng.Float64(), 0.2, float64(b)+0.9*rng.Float64())
 
 			if center.Subtract(boundary).Length() <= 0.9 {
 				continue
 			}
 
 			if chooseMat < 0.8 {
-				albedo := Color{vec.Random().Hadamard(vec.Random())}
+				albedo := Color{vec.Random(rng).Hadamard(vec.Random(rng))}
 				material := Lambertian{albedo}
 				world = append(world, Sphere{center, 0.2, material})
 			} else if chooseMat < 0.95 {
-				albedo := Color{vec.RandomRange(0.5, 1)}
-				// fuzz in range [0, 0.5)
-				fuzz := (rand.Float64() + 1) / 4
-				material := Metal{albedo, fuzz}
+				albedo := Color{vec.RandomRange(rng, 0.5, 1)}
+				// roughness in range [0.25, 0.5)
+				roughness := (rng.Float64() + 1) / 4
+				material := Metal{Albedo: albedo, Roughness: roughness}
 				world = append(world, Sphere{center, 0.2, material})
 			} else {
 				world = append(world, Sphere{center, 0.2, glassMat})
@@ -308,21 +592,22 @@ func renderRandomSpheres(opts CameraOpts) {
 		}
 	}
 
-	world = append(world, Sphere{vec.New(0, -1000, 0), 1000, Lambertian{newColor(0.5, 0.5, 0.5)}})
+	checker := CheckerTexture{0.32, newColor(0.2, 0.3, 0.1), newColor(0.9, 0.9, 0.9)}
+	world = append(world, Plane{vec.New(0, 0, 0), vec.New(0, 1, 0), Lambertian{checker}})
 	world = append(world, Sphere{vec.New(0, 1, 0), 1, glassMat})
 	world = append(world, Sphere{vec.New(-4, 1, 0), 1, Lambertian{newColor(0.4, 0.2, 0.1)}})
-	world = append(world, Sphere{vec.New(4, 1, 0), 1, Metal{newColor(0.7, 0.6, 0.5), 0}})
+	world = append(world, Sphere{vec.New(4, 1, 0), 1, Metal{Albedo: newColor(0.7, 0.6, 0.5)}})
 
 	camera := NewCamera(opts)
-	camera.Render(world)
+	return camera.RenderHDR(ctx, NewBVH(world))
 }
 
-func renderSimpleScene(opts CameraOpts) {
-	ground := Sphere{vec.New(0, -100.5, -1), 100, Lambertian{newColor(0.8, 0.8, 0)}}
+func renderSimpleScene(ctx context.Context, opts CameraOpts) (image.Image, error) {
+	ground := Plane{vec.New(0, -0.5, 0), vec.New(0, 1, 0), Lambertian{newColor(0.8, 0.8, 0)}}
 	middleSphere := Sphere{vec.New(0, 0, -1.2), 0.5, Lambertian{newColor(0.1, 0.2, 0.5)}}
-	leftSphere := Sphere{vec.New(-1., 0, -1.), 0.5, Dielectric{1.5}}
-	leftSphereInside := Sphere{vec.New(-1., 0, -1.), 0.4, Dielectric{1. / 1.5}}
-	rightSphere := Sphere{vec.New(1., 0, -1.), 0.5, Metal{newColor(0.8, 0.6, 0.2), 1}}
+	leftSphere := Sphere{vec.New(-1., 0, -1.), 0.5, Dielectric{RefractionIndex: 1.5}}
+	leftSphereInside := Sphere{vec.New(-1., 0, -1.), 0.4, Dielectric{RefractionIndex: 1. / 1.5}}
+	rightSphere := Sphere{vec.New(1., 0, -1.), 0.5, Metal{Albedo: newColor(0.8, 0.6, 0.2), Roughness: 1}}
 	world := make(World, 0, 3)
 	world = append(world, ground)
 	world = append(world, middleSphere)
@@ -331,23 +616,99 @@ func renderSimpleScene(opts CameraOpts) {
 	world = append(world, rightSphere)
 
 	camera := NewCamera(opts)
-	camera.Render(world)
+	return camera.RenderHDR(ctx, world)
+}
+
+func renderLightsScene(ctx context.Context, opts CameraOpts) (image.Image, error) {
+	ground := Plane{vec.New(0, 0, 0), vec.New(0, 1, 0), Lambertian{newColor(0.5, 0.5, 0.5)}}
+	sphere := Sphere{vec.New(0, 1, 0), 1, Lambertian{newColor(0.8, 0.3, 0.2)}}
+	glass := Sphere{vec.New(-2.2, 0.7, 1), 0.7, Dielectric{RefractionIndex: 1.5}}
+	metal := Sphere{vec.New(2.2, 0.7, 1), 0.7, Metal{Albedo: newColor(0.8, 0.8, 0.8), Roughness: 0.1}}
+	overheadLight := Sphere{vec.New(0, 5, 0), 1.5, DiffuseLight{newColor(4, 4, 4)}}
+	sideLight := Sphere{vec.New(-4, 1.5, 3), 0.5, DiffuseLight{newColor(8, 3, 1)}}
+	world := World{ground, sphere, glass, metal, overheadLight, sideLight}
+
+	world, opts.Lights = MarkLights(world)
+	camera := NewCamera(opts)
+	return camera.RenderHDR(ctx, world)
 }
 
 func main() {
-	scene := flag.String("scene", "simple", "random | simple")
+	scene := flag.String("scene", "simple", "random | simple | lights")
+	sceneFile := flag.String("scene-file", "", "JSON file describing the scene to render. Overrides -scene")
 	parallel := flag.Bool("parallel", true, "whether or not to render in parallel")
+	seed := flag.Uint64("seed", 0, "seed for the random numbers used to render. The same seed always produces the same image")
+	out := flag.String("out", "", "file to write the image to (.png, .jpg, .jpeg, .ppm, or the linear float formats .pfm, .hdr, and .exr). If empty, a PPM is written to stdout")
+	timeLimit := flag.Duration("time-limit", 0, "render for this long (e.g. 30s), adding samples past the scene's samples per pixel until the time is up, then write the image. 0 means no limit")
+	toneMapName := flag.String("tonemap", "clamp", "how colors too bright to display are handled: clamp | reinhard | aces")
+	exposure := flag.Float64("exposure", 0, "stops to brighten the image by before tone mapping. Negative values darken it")
+	environmentPath := flag.String("environment", "", "equirectangular .hdr, .png, or .jpg panorama to light the scene with. Replaces the scene's background")
+	environmentRotation := flag.Float64("environment-rotation", 0, "degrees to turn the -environment panorama around the vertical axis")
+	environmentIntensity := flag.Float64("environment-intensity", 1, "how much to brighten the -environment panorama by")
 	flag.Parse()
 
-	if *scene != "random" && *scene != "simple" {
-		fmt.Fprintln(os.Stderr, "scene must be 'random' or 'simple'")
+	if *sceneFile == "" && *scene != "random" && *scene != "simple" && *scene != "lights" {
+		fmt.Fprintln(os.Stderr, "scene must be 'random', 'simple', or 'lights'")
 		fmt.Fprintln(os.Stderr)
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if *scene == "random" {
-		renderRandomSpheres(
+	toneMap, err := ParseToneMap(*toneMapName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var environment Background
+	if *environmentPath != "" {
+		env, err := LoadEnvironmentMap(*environmentPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		env.Rotation = toRadians(*environmentRotation)
+		env.Intensity = *environmentIntensity
+		environment = env
+	}
+
+	if *out != "" {
+		// fail before rendering rather than after
+		if _, err := formatFromPath(*out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// Interrupting the render still writes out whatever was rendered so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeLimit)
+		defer cancel()
+	}
+
+	var img image.Image
+	if *sceneFile != "" {
+		world, opts, loadErr := loadSceneFile(*sceneFile)
+		if loadErr != nil {
+			fmt.Fprintln(os.Stderr, loadErr)
+			os.Exit(1)
+		}
+		opts.Parallel = *parallel
+		opts.Seed = *seed
+		opts.Progressive = *timeLimit > 0
+		opts.ToneMap = toneMap
+		opts.Exposure = *exposure
+		if environment != nil {
+			opts.Background = environment
+		}
+		camera := NewCamera(opts)
+		img, err = camera.RenderHDR(ctx, NewBVH(world))
+	} else if *scene == "random" {
+		img, err = renderRandomSpheres(
+			ctx,
 			CameraOpts{
 				AspectRatio:        16. / 9.,
 				Width:              300,
@@ -359,19 +720,74 @@ func main() {
 				Up:                 vec.New(0, 1, 0),
 				DefocusAngle:       0.6,
 				FocusDist:          10,
+				Background:         environment,
 				Parallel:           *parallel,
+				Seed:               *seed,
+				Progressive:        *timeLimit > 0,
+				ToneMap:            toneMap,
+				Exposure:           *exposure,
 			},
 		)
 	} else if *scene == "simple" {
-		renderSimpleScene(
+		img, err = renderSimpleScene(
+			ctx,
 			CameraOpts{
 				Position:           vec.New(-2, 2, 1),
 				LookAt:             vec.New(0, 0, -1),
 				VerticalFOVDegrees: 20,
 				DefocusAngle:       10,
 				FocusDist:          3.4,
+				Background:         environment,
 				Parallel:           *parallel,
+				Seed:               *seed,
+				Progressive:        *timeLimit > 0,
+				ToneMap:            toneMap,
+				Exposure:           *exposure,
 			},
 		)
+	} else if *scene == "lights" {
+		var background Background = black
+		if environment != nil {
+			background = environment
+		}
+		img, err = renderLightsScene(
+			ctx,
+			CameraOpts{
+				AspectRatio:        16. / 9.,
+				Width:              400,
+				SamplesPerPixel:    500,
+				MaxBounces:         50,
+				VerticalFOVDegrees: 30,
+				Position:           vec.New(0, 3, 10),
+				LookAt:             vec.New(0, 1, 0),
+				Background:         background,
+				Parallel:           *parallel,
+				Seed:               *seed,
+				Progressive:        *timeLimit > 0,
+				ToneMap:            toneMap,
+				Exposure:           *exposure,
+			},
+		)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		fmt.Fprintln(os.Stderr, "Time limit reached. Writing the image rendered so far.")
+	} else if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Interrupted. Writing the partially rendered image.")
+	}
+
+	if *out == "" {
+		w := bufio.NewWriter(os.Stdout)
+		err := encodePPM(w, img)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := writeImage(*out, img); err != nil {
+		log.Fatal(err)
 	}
 }
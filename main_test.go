@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"io"
 	"testing"
 
@@ -8,7 +9,6 @@ import (
 )
 
 var simpleSceneCameraOpts = CameraOpts{
-	Out:                io.Discard,
 	Log:                io.Discard,
 	AspectRatio:        16. / 9.,
 	Width:              50,
@@ -24,7 +24,7 @@ var simpleSceneCameraOpts = CameraOpts{
 
 func BenchmarkRenderSimple(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		renderSimpleScene(simpleSceneCameraOpts)
+		renderSimpleScene(context.Background(), simpleSceneCameraOpts)
 	}
 }
 
@@ -32,12 +32,11 @@ func BenchmarkRenderSimpleParallel(b *testing.B) {
 	opts := simpleSceneCameraOpts
 	opts.Parallel = true
 	for i := 0; i < b.N; i++ {
-		renderSimpleScene(opts)
+		renderSimpleScene(context.Background(), opts)
 	}
 }
 
 var randomSpheresSceneCameraOpts = CameraOpts{
-	Out:                io.Discard,
 	Log:                io.Discard,
 	AspectRatio:        16. / 9.,
 	Width:              50,
@@ -54,7 +53,7 @@ var randomSpheresSceneCameraOpts = CameraOpts{
 
 func BenchmarkRenderRandomSpheres(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		renderRandomSpheres(randomSpheresSceneCameraOpts)
+		renderRandomSpheres(context.Background(), randomSpheresSceneCameraOpts)
 	}
 }
 
@@ -62,6 +61,18 @@ func BenchmarkRenderRandomSpheresParallel(b *testing.B) {
 	opts := randomSpheresSceneCameraOpts
 	opts.Parallel = true
 	for i := 0; i < b.N; i++ {
-		renderRandomSpheres(opts)
+		renderRandomSpheres(context.Background(), opts)
+	}
+}
+
+// BenchmarkRenderRandomSpheresPreviewParallel uses few enough samples per pixel
+// that parallelizing over samples instead of pixels wouldn't keep every core
+// busy.
+func BenchmarkRenderRandomSpheresPreviewParallel(b *testing.B) {
+	opts := randomSpheresSceneCameraOpts
+	opts.SamplesPerPixel = 8
+	opts.Parallel = true
+	for i := 0; i < b.N; i++ {
+		renderRandomSpheres(context.Background(), opts)
 	}
 }
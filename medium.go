@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// ConstantMedium is a volume of something like fog or smoke that fills a
+// boundary shape evenly. Rather than bouncing off of a surface, a ray that
+// enters it travels a random distance before it's scattered by one of the
+// particles inside, and the denser the medium is the sooner that happens.
+//
+// The boundary must be closed and convex (like a Sphere or a box), so that a
+// ray that enters it leaves at most once.
+type ConstantMedium struct {
+	Boundary Hittable
+	// Density is the chance per unit of distance that a ray is scattered.
+	Density float64
+	// PhaseFunction is the material of the particles, which is usually
+	// Isotropic.
+	PhaseFunction Material
+}
+
+func (m ConstantMedium) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	// find where the ray enters and leaves the boundary, even if that's
+	// behind its origin because it starts inside
+	hitEntry, entry := m.Boundary.Hit(ray, math.Inf(-1), math.Inf(1), rng)
+	if !hitEntry {
+		return false, HitRecord{}
+	}
+	hitExit, exit := m.Boundary.Hit(ray, entry.T+0.0001, math.Inf(1), rng)
+	if !hitExit {
+		return false, HitRecord{}
+	}
+
+	tEntry := max(entry.T, tMin)
+	tExit := min(exit.T, tMax)
+	if tEntry >= tExit {
+		return false, HitRecord{}
+	}
+
+	rayLength := ray.Direction.Length()
+	distanceInside := (tExit - tEntry) * rayLength
+	// the distance to the next particle is exponentially distributed
+	hitDistance := -math.Log(1-rng.Float64()) / m.Density
+	if hitDistance > distanceInside {
+		return false, HitRecord{}
+	}
+
+	t := tEntry + hitDistance/rayLength
+	// A particle doesn't have a surface, so the normal and which side was
+	// hit don't mean anything.
+	return true, HitRecord{
+		Ray: ray, T: t, Normal: vec.New(1, 0, 0), Exterior: true,
+		HitPoint: ray.At(t), Material: m.PhaseFunction,
+	}
+}
+
+func (m ConstantMedium) BoundingBox() AABB {
+	return m.Boundary.BoundingBox()
+}
+
+// Isotropic is the material of the particles in a medium. It scatters light
+// equally in every direction.
+type Isotropic struct {
+	Albedo Texture
+}
+
+func (i Isotropic) Scatter(record HitRecord, rng *rand.Rand) (scattered bool, scatteredRay Ray, attenuation Color) {
+	newRay := Ray{record.HitPoint, vec.RandomUnit(rng), record.Ray.Time}
+	return true, newRay, i.Albedo.Value(record.U, record.V, record.HitPoint)
+}
+
+func (i Isotropic) Eval(record HitRecord, direction Vec3) Color {
+	albedo := i.Albedo.Value(record.U, record.V, record.HitPoint)
+	return Color{albedo.Vec.Divide(4 * math.Pi)}
+}
+
+func (i Isotropic) PDF(record HitRecord, direction Vec3) float64 {
+	return 1 / (4 * math.Pi)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestConstantMediumTransmittance(t *testing.T) {
+	// A ray crossing a medium makes it all the way through with a chance of
+	// e^(-density * distance).
+	boundary := NewBox(vec.New(-1, -1, -1), vec.New(1, 1, 1), Lambertian{white})
+	medium := ConstantMedium{boundary, 0.5, Isotropic{white}}
+	rng := rand.New(rand.NewPCG(9, 10))
+
+	tests := []struct {
+		name   string
+		origin Vec3
+		// distance is how far the ray travels through the medium
+		distance float64
+	}{
+		{"outside", vec.New(0, 0, 5), 2},
+		{"inside", vec.New(0, 0, 0), 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			const rays = 20000
+			passed := 0
+			for range rays {
+				// the direction isn't a unit vector to check that
+				// distances are measured in the world and not along t
+				ray := Ray{Origin: test.origin, Direction: vec.New(0, 0, -3)}
+				hit, record := medium.Hit(ray, 0.001, math.Inf(1), rng)
+				if !hit {
+					passed++
+					continue
+				}
+				if record.HitPoint.Z > 1 || record.HitPoint.Z < -1 {
+					t.Fatalf("hit %v is outside of the medium", record.HitPoint)
+				}
+			}
+			got := float64(passed) / rays
+			want := math.Exp(-0.5 * test.distance)
+			if math.Abs(got-want) > 0.02 {
+				t.Errorf("expected %.3f of the rays to pass through, got %.3f", want, got)
+			}
+		})
+	}
+}
+
+func TestLoadSceneConstantMedium(t *testing.T) {
+	scene := `{
+		"materials": {"smoke": {"type": "isotropic", "albedo": [0.5, 0.5, 0.5]}},
+		"objects": [{
+			"type": "constant_medium", "density": 0.1, "material": "smoke",
+			"boundary": {"type": "sphere", "center": [0, 0, 0], "radius": 2}
+		}]
+	}`
+	world, _, err := loadScene(strings.NewReader(scene), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	medium, ok := world[0].(ConstantMedium)
+	if !ok {
+		t.Fatalf("expected a ConstantMedium, got %T", world[0])
+	}
+	if _, ok := medium.PhaseFunction.(Isotropic); !ok || medium.Density != 0.1 {
+		t.Errorf("unexpected medium %+v", medium)
+	}
+
+	_, _, err = loadScene(strings.NewReader(`{
+		"materials": {"smoke": {"type": "isotropic"}},
+		"objects": [{"type": "constant_medium", "density": 0.1, "material": "smoke"}]
+	}`), ".")
+	if err == nil || !strings.Contains(err.Error(), "constant_medium must have a boundary") {
+		t.Errorf("expected an error about the missing boundary, got %v", err)
+	}
+}
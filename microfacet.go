@@ -0,0 +1,280 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// ComplexIOR is the index of refraction of a conductor, per color channel.
+// Eta is the real part and K, the extinction coefficient, is the imaginary
+// part that makes light die out before it gets far into the metal.
+type ComplexIOR struct {
+	Eta Color
+	K   Color
+}
+
+// These are measured indices sampled at roughly the red, green, and blue
+// wavelengths of 650nm, 550nm, and 450nm.
+var (
+	GoldIOR      = ComplexIOR{newColor(0.143, 0.374, 1.442), newColor(3.983, 2.385, 1.603)}
+	CopperIOR    = ComplexIOR{newColor(0.200, 0.924, 1.102), newColor(3.912, 2.452, 2.142)}
+	AluminiumIOR = ComplexIOR{newColor(1.657, 0.880, 0.521), newColor(9.224, 6.270, 4.837)}
+)
+
+// Fresnel returns the fraction of unpolarized light that the conductor
+// reflects when it arrives at an angle with the given cosine to the normal.
+func (ior ComplexIOR) Fresnel(cosine float64) Color {
+	return newColor(
+		fresnelConductor(cosine, ior.Eta.R(), ior.K.R()),
+		fresnelConductor(cosine, ior.Eta.G(), ior.K.G()),
+		fresnelConductor(cosine, ior.Eta.B(), ior.K.B()),
+	)
+}
+
+// fresnelConductor is the exact Fresnel equation for a conductor, averaged
+// over the s and p polarizations, as it's written in PBRT.
+func fresnelConductor(cosine, eta, k float64) float64 {
+	cosine = min(max(cosine, 0), 1)
+	cos2 := cosine * cosine
+	sin2 := 1 - cos2
+	eta2 := eta * eta
+	k2 := k * k
+
+	t0 := eta2 - k2 - sin2
+	a2PlusB2 := math.Sqrt(t0*t0 + 4*eta2*k2)
+	t1 := a2PlusB2 + cos2
+	a := math.Sqrt(max(0, (a2PlusB2+t0)/2))
+	t2 := 2 * cosine * a
+	rs := (t1 - t2) / (t1 + t2)
+
+	t3 := cos2*a2PlusB2 + sin2*sin2
+	t4 := t2 * sin2
+	rp := rs * (t3 - t4) / (t3 + t4)
+	return (rs + rp) / 2
+}
+
+// fresnelSchlick approximates the Fresnel reflectance of a surface that
+// reflects f0 looking straight on.
+func fresnelSchlick(f0 Color, cosine float64) Color {
+	weight := math.Pow(1-min(max(cosine, 0), 1), 5)
+	return Color{f0.Vec.Scale(1 - weight).Add(white.Vec.Scale(weight))}
+}
+
+// shadingFrame converts directions to and from coordinates where the normal
+// is +Z, which is where the microfacet formulas are simplest.
+type shadingFrame struct {
+	tangent, bitangent, normal Vec3
+}
+
+func newShadingFrame(normal Vec3) shadingFrame {
+	tangent, bitangent := vec.Basis(normal)
+	return shadingFrame{tangent, bitangent, normal}
+}
+
+func (f shadingFrame) toLocal(v Vec3) Vec3 {
+	return vec.New(v.Dot(f.tangent), v.Dot(f.bitangent), v.Dot(f.normal))
+}
+
+func (f shadingFrame) toWorld(v Vec3) Vec3 {
+	return f.tangent.Scale(v.X).Add(f.bitangent.Scale(v.Y)).Add(f.normal.Scale(v.Z))
+}
+
+// ggx is the GGX (or Trowbridge-Reitz) distribution of microfacet normals. All
+// of its directions are unit vectors in a shadingFrame.
+type ggx struct {
+	alpha float64
+}
+
+// newGGX squares roughness to get alpha, which makes roughness look about
+// linear to the eye.
+func newGGX(roughness float64) ggx {
+	return ggx{roughness * roughness}
+}
+
+// d is the density of microfacets facing h, per unit of solid angle and
+// projected area.
+func (g ggx) d(h Vec3) float64 {
+	if h.Z <= 0 {
+		return 0
+	}
+	alpha2 := g.alpha * g.alpha
+	denominator := h.Z*h.Z*(alpha2-1) + 1
+	return alpha2 / (math.Pi * denominator * denominator)
+}
+
+// lambda is Smith's auxiliary function, which g1 and g2 are built from.
+func (g ggx) lambda(w Vec3) float64 {
+	cos2 := w.Z * w.Z
+	if cos2 == 0 {
+		return math.Inf(1)
+	}
+	tan2 := (1 - cos2) / cos2
+	return (math.Sqrt(1+g.alpha*g.alpha*tan2) - 1) / 2
+}
+
+// g1 is the fraction of microfacets facing h that can be seen from w.
+func (g ggx) g1(w Vec3) float64 {
+	return 1 / (1 + g.lambda(w))
+}
+
+// g2 is the fraction of microfacets that can be seen from both wo and wi,
+// taking into account that a facet is more likely to be hidden from both when
+// it's low down.
+func (g ggx) g2(wo, wi Vec3) float64 {
+	return 1 / (1 + g.lambda(wo) + g.lambda(wi))
+}
+
+// sampleVisibleNormal picks a microfacet normal in proportion to how much of
+// it can be seen from wo, using the method from "Sampling the GGX
+// Distribution of Visible Normals" by Heitz. Unlike sampling d alone, it never
+// picks facets that face away from wo.
+func (g ggx) sampleVisibleNormal(wo Vec3, rng *rand.Rand) Vec3 {
+	// stretch the view so that the facets are a hemisphere
+	v := vec.New(g.alpha*wo.X, g.alpha*wo.Y, wo.Z).UnitVector()
+	lengthSquared := v.X*v.X + v.Y*v.Y
+	t1 := vec.New(1, 0, 0)
+	if lengthSquared > 0 {
+		t1 = vec.New(-v.Y, v.X, 0).Divide(math.Sqrt(lengthSquared))
+	}
+	t2 := v.Cross(t1)
+
+	// pick a point on the disk that the hemisphere's visible half projects to
+	r := math.Sqrt(rng.Float64())
+	phi := 2 * math.Pi * rng.Float64()
+	p1 := r * math.Cos(phi)
+	p2 := r * math.Sin(phi)
+	s := (1 + v.Z) / 2
+	p2 = (1-s)*math.Sqrt(1-p1*p1) + s*p2
+
+	// lift it onto the hemisphere and unstretch it
+	n := t1.Scale(p1).Add(t2.Scale(p2)).Add(v.Scale(math.Sqrt(max(0, 1-p1*p1-p2*p2))))
+	return vec.New(g.alpha*n.X, g.alpha*n.Y, max(0, n.Z)).UnitVector()
+}
+
+// visibleNormalPDF is the density of sampleVisibleNormal picking h.
+func (g ggx) visibleNormalPDF(wo, h Vec3) float64 {
+	if wo.Z <= 0 {
+		return 0
+	}
+	return g.g1(wo) * max(0, wo.Dot(h)) * g.d(h) / wo.Z
+}
+
+// reflectionPDF is the density of directions that come from reflecting wo
+// about a visible normal.
+func (g ggx) reflectionPDF(wo, wi Vec3) float64 {
+	h := wo.Add(wi)
+	if vec.IsNearZero(h) {
+		return 0
+	}
+	h = h.UnitVector()
+	cosine := wo.Dot(h)
+	if cosine <= 0 {
+		return 0
+	}
+	return g.visibleNormalPDF(wo, h) / (4 * cosine)
+}
+
+// fresnelDielectric returns the fraction of unpolarized light that's
+// reflected when it arrives at a boundary between two dielectrics at an angle
+// with the given cosine. eta is the index of refraction on the far side of the
+// boundary over the one on the near side. All the light is reflected past the
+// critical angle.
+func fresnelDielectric(cosine, eta float64) float64 {
+	cosine = min(max(cosine, 0), 1)
+	sin2Transmitted := (1 - cosine*cosine) / (eta * eta)
+	if sin2Transmitted >= 1 {
+		return 1
+	}
+	cosTransmitted := math.Sqrt(1 - sin2Transmitted)
+	parallel := (eta*cosine - cosTransmitted) / (eta*cosine + cosTransmitted)
+	perpendicular := (cosine - eta*cosTransmitted) / (cosine + eta*cosTransmitted)
+	return (parallel*parallel + perpendicular*perpendicular) / 2
+}
+
+// roughDielectric is a boundary between two dielectrics whose microfacets
+// follow a GGX distribution. Light either reflects off a facet or refracts
+// through it, in proportion to the facet's Fresnel reflectance, as in
+// "Microfacet Models for Refraction through Rough Surfaces" by Walter et al.
+// wo is always on the +Z side.
+//
+// Radiance isn't scaled by the squared ratio of the indices as it crosses, to
+// match Dielectric. For rays that go into an object and back out, the scaling
+// cancels out anyway.
+type roughDielectric struct {
+	ggx
+	// eta is the index of refraction on the -Z side over the one on the +Z
+	// side.
+	eta float64
+}
+
+// halfVector returns the facet normal that would send wo to wi, and whether
+// it's a reflection. ok is false if no facet that can be seen from both could
+// do it.
+func (r roughDielectric) halfVector(wo, wi Vec3) (h Vec3, reflected bool, ok bool) {
+	if wo.Z <= 0 || wi.Z == 0 {
+		return Vec3{}, false, false
+	}
+	reflected = wi.Z > 0
+	etap := 1.
+	if !reflected {
+		etap = r.eta
+	}
+	h = wo.Add(wi.Scale(etap))
+	if vec.IsNearZero(h) {
+		return Vec3{}, false, false
+	}
+	h = h.UnitVector()
+	if h.Z < 0 {
+		h = h.Scale(-1)
+	}
+	if h.Dot(wo) <= 0 || h.Dot(wi)*wi.Z <= 0 {
+		return Vec3{}, false, false
+	}
+	return h, reflected, true
+}
+
+// eval is the BSDF times the cosine of wi, not counting any tint.
+func (r roughDielectric) eval(wo, wi Vec3) float64 {
+	h, reflected, ok := r.halfVector(wo, wi)
+	if !ok {
+		return 0
+	}
+	fresnel := fresnelDielectric(wo.Dot(h), r.eta)
+	if reflected {
+		return r.d(h) * r.g2(wo, wi) * fresnel / (4 * wo.Z)
+	}
+	denominator := wi.Dot(h) + wo.Dot(h)/r.eta
+	return r.d(h) * r.g2(wo, wi) * (1 - fresnel) * math.Abs(wi.Dot(h)*wo.Dot(h)) / (wo.Z * denominator * denominator)
+}
+
+// pdf is the density of sample picking wi.
+func (r roughDielectric) pdf(wo, wi Vec3) float64 {
+	h, reflected, ok := r.halfVector(wo, wi)
+	if !ok {
+		return 0
+	}
+	fresnel := fresnelDielectric(wo.Dot(h), r.eta)
+	if reflected {
+		return r.visibleNormalPDF(wo, h) / (4 * wo.Dot(h)) * fresnel
+	}
+	denominator := wi.Dot(h) + wo.Dot(h)/r.eta
+	return r.visibleNormalPDF(wo, h) * math.Abs(wi.Dot(h)) / (denominator * denominator) * (1 - fresnel)
+}
+
+// sample picks a facet that can be seen from wo and then reflects or
+// refracts wo through it. ok is false if the new direction ends up on the
+// wrong side of the surface.
+func (r roughDielectric) sample(wo Vec3, rng *rand.Rand) (wi Vec3, ok bool) {
+	h := r.sampleVisibleNormal(wo, rng)
+	cosine := wo.Dot(h)
+	if rng.Float64() < fresnelDielectric(cosine, r.eta) {
+		wi = reflect(wo.Scale(-1), h)
+		return wi, wi.Z > 0
+	}
+	// refracting is always possible here, since fresnelDielectric is 1
+	// otherwise
+	wi = refract(wo.Scale(-1), h, 1/r.eta).UnitVector()
+	return wi, wi.Z < 0
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestFresnelConductor(t *testing.T) {
+	for _, ior := range []ComplexIOR{GoldIOR, CopperIOR, AluminiumIOR} {
+		// Looking straight on, the Fresnel equations simplify to this.
+		eta, k := ior.Eta.R(), ior.K.R()
+		want := ((eta-1)*(eta-1) + k*k) / ((eta+1)*(eta+1) + k*k)
+		if got := ior.Fresnel(1).R(); math.Abs(got-want) > 1e-9 {
+			t.Errorf("%v: expected %v looking straight on, got %v", ior, want, got)
+		}
+		if got := ior.Fresnel(0); got.Vec.Subtract(white.Vec).Length() > 1e-9 {
+			t.Errorf("%v: expected grazing light to all be reflected, got %v", ior, got)
+		}
+	}
+
+	// gold is yellow
+	gold := GoldIOR.Fresnel(1)
+	if !(gold.R() > gold.G() && gold.G() > gold.B()) {
+		t.Errorf("expected gold to reflect more red than green than blue, got %v", gold)
+	}
+}
+
+// obliqueHit is where a ray that comes in at an angle hits a floor facing +Y.
+func obliqueHit() HitRecord {
+	return NewHitRecord(Ray{Origin: vec.New(-1, 1, 0), Direction: vec.New(1, -0.6, 0.2)}, 1, vec.New(0, 1, 0), vec.New(0, 0, 0), nil)
+}
+
+// checkScatterMatchesEval checks that material, which must also be a
+// Material, weights the rays it scatters by Eval over PDF and picks them with
+// the density PDF, so that on average it scatters the integral of Eval over
+// every direction.
+//
+// The integral is estimated from directions picked uniformly and directions
+// picked by Scatter, combined with the balance heuristic. Either kind alone
+// would be too noisy or would take PDF on trust, but together the estimate is
+// steady even for sharply peaked materials, and it's only right if PDF really
+// is the density of Scatter's directions.
+func checkScatterMatchesEval(t *testing.T, material BSDF, record HitRecord) {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(23, 24))
+	const samples = 100000
+	const uniformPDF = 1 / (4 * math.Pi)
+	var scattered, integral Color
+	for range samples {
+		if ok, ray, attenuation := material.(Material).Scatter(record, rng); ok {
+			direction := ray.Direction.UnitVector()
+			eval := material.Eval(record, direction)
+			pdf := material.PDF(record, direction)
+			want := eval.Vec.Divide(pdf)
+			if attenuation.Vec.Subtract(want).Length() > 1e-6*want.Length() {
+				t.Fatalf("expected an attenuation of %v going %v, got %v", want, direction, attenuation)
+			}
+			scattered.Vec = scattered.Vec.Add(attenuation.Vec)
+			integral.Vec = integral.Vec.Add(eval.Vec.Divide(uniformPDF + pdf))
+		}
+		direction := vec.RandomUnit(rng)
+		eval := material.Eval(record, direction)
+		integral.Vec = integral.Vec.Add(eval.Vec.Divide(uniformPDF + material.PDF(record, direction)))
+	}
+	scattered.Vec = scattered.Vec.Divide(samples)
+	integral.Vec = integral.Vec.Divide(samples)
+	if scattered.Vec.Subtract(integral.Vec).Length() > 0.01*integral.Vec.Length() {
+		t.Errorf("scattering reflects %v, but Eval integrates to %v", scattered, integral)
+	}
+}
+
+func TestMetalScatterMatchesEval(t *testing.T) {
+	for _, roughness := range []float64{0.05, 0.3, 1} {
+		for name, ior := range map[string]*ComplexIOR{"albedo": nil, "copper": &CopperIOR} {
+			metal := Metal{newColor(0.9, 0.8, 0.7), roughness, ior}
+			t.Run(fmt.Sprintf("%s roughness %v", name, roughness), func(t *testing.T) {
+				checkScatterMatchesEval(t, metal, obliqueHit())
+			})
+		}
+	}
+}
+
+func TestSmoothMetalKeepsItsEnergy(t *testing.T) {
+	record := NewHitRecord(Ray{Origin: vec.New(0, 1, 0), Direction: vec.New(0.1, -1, 0)}, 1, vec.New(0, 1, 0), vec.New(0, 0, 0), nil)
+	rng := rand.New(rand.NewPCG(25, 26))
+	metal := Metal{Albedo: white, Roughness: 0.2}
+	const samples = 10000
+	var sum float64
+	for range samples {
+		if ok, _, attenuation := metal.Scatter(record, rng); ok {
+			sum += attenuation.R()
+		}
+	}
+	// Only a sliver of light should bounce between facets and be lost.
+	if mean := sum / samples; mean < 0.98 || mean > 1 {
+		t.Errorf("expected a white metal to reflect nearly all light, got %v", mean)
+	}
+}
+
+func TestRoughDielectricScatterMatchesEval(t *testing.T) {
+	records := []struct {
+		name   string
+		record HitRecord
+	}{
+		{"going in", obliqueHit()},
+		// steep enough that a lot of it is reflected back inside
+		{"coming out", NewHitRecord(Ray{Origin: vec.New(-1, -1, 0), Direction: vec.New(1, 0.6, 0.2)}, 1, vec.New(0, 1, 0), vec.New(0, 0, 0), nil)},
+	}
+	for _, test := range records {
+		for _, roughness := range []float64{0.5, 0.9} {
+			glass := Dielectric{RefractionIndex: 1.5, Roughness: roughness}
+			t.Run(fmt.Sprintf("%s roughness %v", test.name, roughness), func(t *testing.T) {
+				checkScatterMatchesEval(t, glass, test.record)
+			})
+		}
+	}
+}
+
+func TestDielectricAbsorption(t *testing.T) {
+	absorption := newColor(0.1, 0.5, 2)
+	// The ray goes 2 units from inside the glass to get to the surface.
+	inside := NewHitRecord(Ray{Origin: vec.New(0, 0, 0), Direction: vec.New(2, 0, 0)}, 1, vec.New(1, 0, 0), vec.New(2, 0, 0), nil)
+	want := newColor(math.Exp(-0.2), math.Exp(-1), math.Exp(-4))
+	// and none is absorbed on the way to the outside of the surface
+	outside := NewHitRecord(Ray{Origin: vec.New(4, 0, 0), Direction: vec.New(-2, 0, 0)}, 1, vec.New(1, 0, 0), vec.New(2, 0, 0), nil)
+
+	rng := rand.New(rand.NewPCG(35, 36))
+	for _, roughness := range []float64{0, 0.3} {
+		glass := Dielectric{RefractionIndex: 1.5, Roughness: roughness, Absorption: absorption}
+		for range 100 {
+			if ok, _, attenuation := glass.Scatter(inside, rng); ok && attenuation.Vec.Subtract(want.Vec).Length() > 0.05 {
+				t.Fatalf("roughness %v: expected about %v to make it through, got %v", roughness, want, attenuation)
+			}
+			if ok, _, attenuation := glass.Scatter(outside, rng); ok && attenuation.Vec.Subtract(white.Vec).Length() > 0.05 {
+				t.Fatalf("roughness %v: expected nothing to be absorbed outside, got %v", roughness, attenuation)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package noise makes smooth, random-looking patterns for procedural
+// textures.
+package noise
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// pointCount is how many random gradients there are. The noise repeats every
+// pointCount units along each axis.
+const pointCount = 256
+
+// Perlin is Ken Perlin's gradient noise. Every point on the integer lattice
+// gets a random gradient, and the noise between them is smoothly interpolated,
+// so it varies about once per unit.
+type Perlin struct {
+	gradients [pointCount]vec.Vec3
+	permX     [pointCount]int
+	permY     [pointCount]int
+	permZ     [pointCount]int
+}
+
+// NewPerlin returns noise whose pattern is determined by seed.
+func NewPerlin(seed uint64) *Perlin {
+	rng := rand.New(rand.NewPCG(seed, 0))
+	p := &Perlin{}
+	for i := range p.gradients {
+		p.gradients[i] = vec.RandomUnit(rng)
+	}
+	copy(p.permX[:], rng.Perm(pointCount))
+	copy(p.permY[:], rng.Perm(pointCount))
+	copy(p.permZ[:], rng.Perm(pointCount))
+	return p
+}
+
+// Noise returns the noise at point. It's in the range [-1,1], and it's 0 at
+// every point on the integer lattice.
+func (p *Perlin) Noise(point vec.Vec3) float64 {
+	floorX, floorY, floorZ := math.Floor(point.X), math.Floor(point.Y), math.Floor(point.Z)
+	u, v, w := point.X-floorX, point.Y-floorY, point.Z-floorZ
+	i, j, k := int(floorX), int(floorY), int(floorZ)
+
+	// Hermite smoothing hides the grid that the interpolation would
+	// otherwise show.
+	uu := u * u * (3 - 2*u)
+	vv := v * v * (3 - 2*v)
+	ww := w * w * (3 - 2*w)
+
+	var sum float64
+	for di := range 2 {
+		for dj := range 2 {
+			for dk := range 2 {
+				gradient := p.gradients[p.permX[(i+di)&(pointCount-1)]^
+					p.permY[(j+dj)&(pointCount-1)]^
+					p.permZ[(k+dk)&(pointCount-1)]]
+				offset := vec.New(u-float64(di), v-float64(dj), w-float64(dk))
+				weight := lerpWeight(di, uu) * lerpWeight(dj, vv) * lerpWeight(dk, ww)
+				sum += weight * gradient.Dot(offset)
+			}
+		}
+	}
+	// The most that a sum of dot products with unit gradients can reach is
+	// sqrt(3)/2, so this stretches the result to fill [-1,1].
+	return max(min(sum*2/math.Sqrt(3), 1), -1)
+}
+
+// lerpWeight is the weight of the corner at offset 0 or 1 for a smoothed
+// fraction t of the way across a cell.
+func lerpWeight(corner int, t float64) float64 {
+	if corner == 1 {
+		return t
+	}
+	return 1 - t
+}
+
+// Turbulence sums the absolute value of octaves layers of noise, each twice as
+// detailed and half as strong as the last. It's in the range [0,2), and it
+// has sharp creases where the noise crosses 0, which looks like swirling
+// fluid.
+func (p *Perlin) Turbulence(point vec.Vec3, octaves int) float64 {
+	var sum float64
+	weight := 1.
+	for range octaves {
+		sum += weight * math.Abs(p.Noise(point))
+		weight /= 2
+		point = point.Scale(2)
+	}
+	return sum
+}
+
+// FBM is fractional Brownian motion: octaves layers of noise, each lacunarity
+// times as detailed as the last and with gain times its strength. The usual
+// choice is a lacunarity of 2 and a gain of 0.5. The result is scaled back
+// into the range [-1,1].
+func (p *Perlin) FBM(point vec.Vec3, octaves int, lacunarity, gain float64) float64 {
+	var sum, total float64
+	amplitude := 1.
+	for range octaves {
+		sum += amplitude * p.Noise(point)
+		total += amplitude
+		amplitude *= gain
+		point = point.Scale(lacunarity)
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
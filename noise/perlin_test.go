@@ -0,0 +1,46 @@
+package noise
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestPerlinNoise(t *testing.T) {
+	perlin := NewPerlin(1)
+	if NewPerlin(1).Noise(vec.New(0.3, 1.7, -2.2)) != perlin.Noise(vec.New(0.3, 1.7, -2.2)) {
+		t.Error("expected the same seed to make the same noise")
+	}
+	if perlin.Noise(vec.New(3, -4, 5)) != 0 {
+		t.Error("expected the noise to be 0 on the integer lattice")
+	}
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	var lowest, highest float64
+	for range 10000 {
+		p := vec.RandomRange(rng, -50, 50)
+		n := perlin.Noise(p)
+		if n < -1 || n > 1 {
+			t.Fatalf("noise at %v is %v, which is outside [-1,1]", p, n)
+		}
+		lowest, highest = min(lowest, n), max(highest, n)
+
+		// the noise should be smooth, not jump around
+		nearby := perlin.Noise(p.Add(vec.New(1e-6, 1e-6, 1e-6)))
+		if math.Abs(nearby-n) > 1e-4 {
+			t.Fatalf("noise jumps from %v to %v near %v", n, nearby, p)
+		}
+
+		if turbulence := perlin.Turbulence(p, 7); turbulence < 0 || turbulence >= 2 {
+			t.Fatalf("turbulence at %v is %v, which is outside [0,2)", p, turbulence)
+		}
+		if fbm := perlin.FBM(p, 5, 2, 0.5); fbm < -1 || fbm > 1 {
+			t.Fatalf("fBm at %v is %v, which is outside [-1,1]", p, fbm)
+		}
+	}
+	if lowest > -0.5 || highest < 0.5 {
+		t.Errorf("expected the noise to cover most of [-1,1], got [%v,%v]", lowest, highest)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// LoadOBJ reads the Wavefront OBJ mesh at path and returns its faces as
+// triangles. Faces with more than three corners are split into a fan of
+// triangles.
+//
+// If the file refers to an MTL material library, its materials are mapped onto
+// Lambertian, Metal, Dielectric, and DiffuseLight as well as possible.
+// defaultMaterial is used for faces that don't have a material, and it must not
+// be nil if there are any.
+//
+// A mesh can have a lot of triangles, so it's a good idea to put the result in
+// a BVH.
+func LoadOBJ(path string, defaultMaterial Material) (World, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mesh, err := parseOBJ(f, filepath.Dir(path), defaultMaterial)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return mesh, nil
+}
+
+// objVertex is one corner of a face. Indexes are 0-based, and texture and
+// normal are -1 if the corner doesn't have them.
+type objVertex struct {
+	position int
+	texture  int
+	normal   int
+}
+
+// parseOBJ reads an OBJ mesh from r. dir is the directory that MTL libraries are
+// found relative to.
+func parseOBJ(r io.Reader, dir string, defaultMaterial Material) (World, error) {
+	var (
+		positions []Vec3
+		texCoords []TexCoord
+		normals   []Vec3
+		materials = map[string]Material{}
+		material  = defaultMaterial
+		mesh      World
+	)
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "v":
+			var v Vec3
+			v, err = parseOBJVec(fields[1:])
+			positions = append(positions, v)
+		case "vt":
+			var v Vec3
+			v, err = parseOBJVec(fields[1:])
+			texCoords = append(texCoords, TexCoord{v.X, v.Y})
+		case "vn":
+			var v Vec3
+			v, err = parseOBJVec(fields[1:])
+			// Some exporters write zero normals for degenerate faces.
+			// Triangle falls back to its flat normal for those.
+			if v != (Vec3{}) {
+				v = v.UnitVector()
+			}
+			normals = append(normals, v)
+		case "f":
+			mesh, err = appendOBJFace(mesh, fields[1:], positions, texCoords, normals, material)
+		case "mtllib":
+			for _, name := range fields[1:] {
+				if err = loadMTL(filepath.Join(dir, name), materials); err != nil {
+					break
+				}
+			}
+		case "usemtl":
+			if len(fields) != 2 {
+				err = errors.New("usemtl needs a material name")
+				break
+			}
+			var ok bool
+			material, ok = materials[fields[1]]
+			if !ok {
+				err = fmt.Errorf("unknown material %q", fields[1])
+			}
+		default:
+			// Groups, smoothing groups, lines, etc. don't matter for rendering.
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mesh, nil
+}
+
+// parseOBJVec parses the numbers after a v, vt, or vn statement. Missing
+// components are 0.
+func parseOBJVec(fields []string) (Vec3, error) {
+	if len(fields) == 0 {
+		return Vec3{}, errors.New("missing coordinates")
+	}
+	var components [3]float64
+	for i := 0; i < len(fields) && i < 3; i++ {
+		f, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return Vec3{}, err
+		}
+		components[i] = f
+	}
+	return vec.New(components[0], components[1], components[2]), nil
+}
+
+func appendOBJFace(mesh World, fields []string, positions []Vec3, texCoords []TexCoord, normals []Vec3, material Material) (World, error) {
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("a face needs at least 3 vertices, got %d", len(fields))
+	}
+	if material == nil {
+		return nil, errors.New("face has no material")
+	}
+
+	vertices := make([]objVertex, len(fields))
+	for i, field := range fields {
+		var err error
+		vertices[i], err = parseOBJVertex(field, len(positions), len(texCoords), len(normals))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 1; i < len(vertices)-1; i++ {
+		corners := [3]objVertex{vertices[0], vertices[i], vertices[i+1]}
+		tri := Triangle{
+			A:        positions[corners[0].position],
+			B:        positions[corners[1].position],
+			C:        positions[corners[2].position],
+			Material: material,
+		}
+		if corners[0].texture >= 0 && corners[1].texture >= 0 && corners[2].texture >= 0 {
+			tri.TexA = texCoords[corners[0].texture]
+			tri.TexB = texCoords[corners[1].texture]
+			tri.TexC = texCoords[corners[2].texture]
+		}
+		if corners[0].normal >= 0 && corners[1].normal >= 0 && corners[2].normal >= 0 {
+			tri.NormalA = normals[corners[0].normal]
+			tri.NormalB = normals[corners[1].normal]
+			tri.NormalC = normals[corners[2].normal]
+		}
+		mesh = append(mesh, tri)
+	}
+	return mesh, nil
+}
+
+// parseOBJVertex parses a face vertex like "1", "1/2", "1//3", or "1/2/3".
+func parseOBJVertex(field string, numPositions, numTexCoords, numNormals int) (objVertex, error) {
+	parts := strings.Split(field, "/")
+	if len(parts) > 3 {
+		return objVertex{}, fmt.Errorf("invalid face vertex %q", field)
+	}
+	vertex := objVertex{-1, -1, -1}
+	indexes := []*int{&vertex.position, &vertex.texture, &vertex.normal}
+	counts := []int{numPositions, numTexCoords, numNormals}
+	for i, part := range parts {
+		if part == "" {
+			if i == 0 {
+				return objVertex{}, fmt.Errorf("face vertex %q has no position", field)
+			}
+			continue
+		}
+		index, err := strconv.Atoi(part)
+		if err != nil {
+			return objVertex{}, fmt.Errorf("invalid face vertex %q: %w", field, err)
+		}
+		// Indexes start at 1, and negative ones count back from the most
+		// recently defined element.
+		if index < 0 {
+			index += counts[i]
+		} else {
+			index--
+		}
+		if index < 0 || index >= counts[i] {
+			return objVertex{}, fmt.Errorf("face vertex %q refers to an element that doesn't exist", field)
+		}
+		*indexes[i] = index
+	}
+	return vertex, nil
+}
+
+// mtlMaterial holds the statements from an MTL file that are used to pick a
+// Material.
+type mtlMaterial struct {
+	diffuse      Color
+	specular     Color
+	emissive     Color
+	shininess    float64
+	opacity      float64
+	opticalIndex float64
+	illum        int
+	// diffuseMap is the path of an image texture that replaces diffuse
+	diffuseMap string
+}
+
+// loadMTL reads the MTL material library at path into materials.
+func loadMTL(path string, materials map[string]Material) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := parseMTL(f, filepath.Dir(path), materials); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// parseMTL reads an MTL material library from r into materials. dir is the
+// directory that texture maps are found relative to.
+func parseMTL(r io.Reader, dir string, materials map[string]Material) error {
+	var (
+		name    string
+		current *mtlMaterial
+	)
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		material, err := current.material(dir)
+		if err != nil {
+			return fmt.Errorf("material %q: %w", name, err)
+		}
+		materials[name] = material
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "newmtl" {
+			if len(fields) != 2 {
+				return fmt.Errorf("line %d: newmtl needs a material name", lineNumber)
+			}
+			if err := finish(); err != nil {
+				return err
+			}
+			name = fields[1]
+			current = &mtlMaterial{diffuse: newColor(0.8, 0.8, 0.8), opacity: 1, opticalIndex: 1.5, illum: 2}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "Kd":
+			current.diffuse, err = parseMTLColor(fields[1:])
+		case "Ks":
+			current.specular, err = parseMTLColor(fields[1:])
+		case "Ke":
+			current.emissive, err = parseMTLColor(fields[1:])
+		case "Ns":
+			current.shininess, err = parseMTLFloat(fields[1:])
+		case "Ni":
+			current.opticalIndex, err = parseMTLFloat(fields[1:])
+		case "d":
+			current.opacity, err = parseMTLFloat(fields[1:])
+		case "Tr":
+			var transparency float64
+			transparency, err = parseMTLFloat(fields[1:])
+			current.opacity = 1 - transparency
+		case "illum":
+			var illum float64
+			illum, err = parseMTLFloat(fields[1:])
+			current.illum = int(illum)
+		case "map_Kd":
+			// options like -blendu come before the file name, which is last
+			if len(fields) < 2 {
+				err = errors.New("map_Kd needs a file name")
+			} else {
+				current.diffuseMap = fields[len(fields)-1]
+			}
+		default:
+			// Texture maps and the like aren't supported.
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return finish()
+}
+
+func parseMTLFloat(fields []string) (float64, error) {
+	if len(fields) == 0 {
+		return 0, errors.New("missing value")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+func parseMTLColor(fields []string) (Color, error) {
+	v, err := parseOBJVec(fields)
+	if err != nil {
+		return Color{}, err
+	}
+	if len(fields) == 1 {
+		// a single value is used for every channel
+		v = vec.New(v.X, v.X, v.X)
+	}
+	return Color{v}, nil
+}
+
+// material picks whichever Material is closest to what the MTL statements
+// describe.
+func (m mtlMaterial) material(dir string) (Material, error) {
+	var diffuse Texture = m.diffuse
+	if m.diffuseMap != "" {
+		texture, err := LoadImageTexture(filepath.Join(dir, m.diffuseMap))
+		if err != nil {
+			return nil, err
+		}
+		diffuse = texture
+	}
+
+	switch {
+	case m.emissive != black:
+		return DiffuseLight{m.emissive}, nil
+	case m.opacity < 1 || m.illum == 4 || m.illum == 6 || m.illum == 7 || m.illum == 9:
+		// Many exporters write Ni 0 for materials that aren't glass, which
+		// isn't a refractive index anything has.
+		refractionIndex := m.opticalIndex
+		if refractionIndex <= 0 {
+			refractionIndex = 1.5
+		}
+		return Dielectric{RefractionIndex: refractionIndex}, nil
+	case m.illum == 3 || m.illum == 5:
+		var albedo Texture = m.specular
+		if m.specular == black {
+			albedo = diffuse
+		}
+		// This maps Phong shininess onto alpha in the same way as the
+		// Beckmann distribution, which is close enough to GGX's.
+		alpha := min(math.Sqrt(2/(m.shininess+2)), 1)
+		return Metal{Albedo: albedo, Roughness: math.Sqrt(alpha)}, nil
+	}
+	return Lambertian{diffuse}, nil
+}
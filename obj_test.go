@@ -0,0 +1,176 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestParseOBJ(t *testing.T) {
+	dir := t.TempDir()
+	mtl := `
+newmtl red
+Kd 0.8 0 0
+newmtl glass
+Ni 1.4
+d 0.5
+`
+	if err := os.WriteFile(filepath.Join(dir, "test.mtl"), []byte(mtl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := `
+mtllib test.mtl
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vn 0 0 1
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+f 1 2 3 # uses the default material
+usemtl red
+f 1/1/1 2/2/1 3/3/1 4/4/1
+usemtl glass
+f -4//1 -3//1 -2//1
+`
+	defaultMaterial := Lambertian{white}
+	mesh, err := parseOBJ(strings.NewReader(obj), dir, defaultMaterial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mesh) != 4 {
+		t.Fatalf("expected 4 triangles (the quad is split in two), got %d", len(mesh))
+	}
+
+	materials := []Material{defaultMaterial, Lambertian{newColor(0.8, 0, 0)}, Lambertian{newColor(0.8, 0, 0)}, Dielectric{RefractionIndex: 1.4}}
+	for i, object := range mesh {
+		if tri := object.(Triangle); tri.Material != materials[i] {
+			t.Errorf("triangle %d: expected material %+v, got %+v", i, materials[i], tri.Material)
+		}
+	}
+
+	quad := mesh[1].(Triangle)
+	if quad.TexC != (TexCoord{1, 1}) {
+		t.Errorf("expected texture coordinates (1, 1), got %+v", quad.TexC)
+	}
+	if quad.NormalB != vec.New(0, 0, 1) {
+		t.Errorf("expected normal (0, 0, 1), got %+v", quad.NormalB)
+	}
+}
+
+func TestParseOBJErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		obj      string
+		material Material
+		err      string
+	}{
+		{"missing vertex", "v 0 0 0\nf 1 2 3", Lambertian{white}, "line 2: face vertex \"2\" refers to an element that doesn't exist"},
+		{"too few vertices", "v 0 0 0\nf 1 1", Lambertian{white}, "line 2: a face needs at least 3 vertices"},
+		{"unknown material", "usemtl gold", Lambertian{white}, "line 1: unknown material \"gold\""},
+		{"no material", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3", nil, "line 4: face has no material"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseOBJ(strings.NewReader(test.obj), ".", test.material)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestTriangleHit(t *testing.T) {
+	tri := Triangle{
+		A:        vec.New(0, 0, 0),
+		B:        vec.New(1, 0, 0),
+		C:        vec.New(0, 1, 0),
+		NormalA:  vec.New(0, 0, 1),
+		NormalB:  vec.New(0, 0, 1),
+		NormalC:  vec.New(0, 0, 1),
+		TexA:     TexCoord{0, 0},
+		TexB:     TexCoord{1, 0},
+		TexC:     TexCoord{0, 1},
+		Material: Lambertian{white},
+	}
+
+	hit, record := tri.Hit(Ray{Origin: vec.New(0.25, 0.5, 1), Direction: vec.New(0, 0, -1)}, 0.001, math.Inf(1), nil)
+	if !hit {
+		t.Fatal("expected the ray to hit the triangle")
+	}
+	if record.T != 1 || !record.Exterior {
+		t.Errorf("expected an exterior hit at t=1, got t=%v exterior=%v", record.T, record.Exterior)
+	}
+	if math.Abs(record.U-0.25) > 1e-9 || math.Abs(record.V-0.5) > 1e-9 {
+		t.Errorf("expected texture coordinates (0.25, 0.5), got (%v, %v)", record.U, record.V)
+	}
+
+	if hit, _ := tri.Hit(Ray{Origin: vec.New(0.75, 0.75, 1), Direction: vec.New(0, 0, -1)}, 0.001, math.Inf(1), nil); hit {
+		t.Error("expected the ray to miss the triangle")
+	}
+
+	hit, record = tri.Hit(Ray{Origin: vec.New(0.25, 0.25, -1), Direction: vec.New(0, 0, 1)}, 0.001, math.Inf(1), nil)
+	if !hit || record.Exterior || record.Normal != vec.New(0, 0, -1) {
+		t.Errorf("expected an interior hit with a normal against the ray, got %+v", record)
+	}
+}
+
+func TestZeroOBJNormals(t *testing.T) {
+	// Some exporters write zero normals for degenerate faces. Those shouldn't
+	// turn into NaN normals that shade as black.
+	obj := `
+v 0 0 0
+v 1 0 0
+v 0 1 0
+vn 0 0 0
+f 1//1 2//1 3//1
+`
+	mesh, err := parseOBJ(strings.NewReader(obj), ".", Lambertian{white})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tri := mesh[0].(Triangle)
+	if tri.NormalA != (Vec3{}) {
+		t.Errorf("expected a zero normal to stay zero, got %+v", tri.NormalA)
+	}
+
+	hit, record := tri.Hit(Ray{Origin: vec.New(0.25, 0.25, 1), Direction: vec.New(0, 0, -1)}, 0.001, math.Inf(1), nil)
+	if !hit {
+		t.Fatal("expected the ray to hit the triangle")
+	}
+	if record.Normal != vec.New(0, 0, 1) {
+		t.Errorf("expected the flat normal (0, 0, 1), got %+v", record.Normal)
+	}
+}
+
+func TestMTLZeroOpticalIndex(t *testing.T) {
+	dir := t.TempDir()
+	mtl := `
+newmtl glass
+Ni 0.000000
+d 0.5
+newmtl window
+Ni -1
+illum 4
+`
+	path := filepath.Join(dir, "test.mtl")
+	if err := os.WriteFile(path, []byte(mtl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	materials := map[string]Material{}
+	if err := loadMTL(path, materials); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"glass", "window"} {
+		if want := (Dielectric{RefractionIndex: 1.5}); materials[name] != want {
+			t.Errorf("%s: expected %+v, got %+v", name, want, materials[name])
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// formatFromPath returns the image format to use for a file, based on its
+// extension.
+func formatFromPath(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".png":
+		return "png", nil
+	case ".jpg", ".jpeg":
+		return "jpeg", nil
+	case ".ppm":
+		return "ppm", nil
+	case ".pfm":
+		return "pfm", nil
+	case ".hdr":
+		return "hdr", nil
+	case ".exr":
+		return "exr", nil
+	}
+	return "", fmt.Errorf("unsupported image extension %q (use .png, .jpg, .jpeg, .ppm, .pfm, .hdr, or .exr)", ext)
+}
+
+// writeImage encodes img to the file at path in the format that matches its
+// extension. Float formats need img to be an *HDRImage.
+func writeImage(path string, img image.Image) (err error) {
+	format, err := formatFromPath(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	if err := encodeImage(w, format, img); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// encodeImage writes img to w as a png, jpeg, ppm, or any of the float formats.
+func encodeImage(w io.Writer, format string, img image.Image) error {
+	if isFloatFormat(format) {
+		return encodeFloatImage(w, format, img)
+	}
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 95})
+	case "ppm":
+		return encodePPM(w, img)
+	}
+	return fmt.Errorf("unsupported image format %q", format)
+}
+
+// encodePPM writes img to w as an ASCII (P3) PPM with 8 bits per channel.
+func encodePPM(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	if _, err := fmt.Fprintf(w, "P3\n%d %d\n255\n", bounds.Dx(), bounds.Dy()); err != nil {
+		return err
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if _, err := fmt.Fprintf(w, "%d %d %d\n", c.R, c.G, c.B); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
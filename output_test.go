@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatFromPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		format string
+	}{
+		{"out.png", "png"},
+		{"out.jpg", "jpeg"},
+		{"renders/out.JPEG", "jpeg"},
+		{"out.ppm", "ppm"},
+		{"out.pfm", "pfm"},
+		{"out.hdr", "hdr"},
+		{"out.exr", "exr"},
+	}
+	for _, test := range tests {
+		format, err := formatFromPath(test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+		} else if format != test.format {
+			t.Errorf("%s: got format %q, want %q", test.path, format, test.format)
+		}
+	}
+
+	for _, path := range []string{"out.bmp", "out"} {
+		if _, err := formatFromPath(path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+// testLDRImage is a small image with a different color in every pixel.
+func testLDRImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 128, 0, 255})
+	img.SetNRGBA(0, 1, color.NRGBA{0, 0, 7, 255})
+	img.SetNRGBA(1, 1, color.NRGBA{10, 20, 30, 255})
+	return img
+}
+
+func TestEncodePPM(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodePPM(&buf, testLDRImage()); err != nil {
+		t.Fatal(err)
+	}
+	want := "P3\n2 2\n255\n" +
+		"255 0 0\n" +
+		"0 128 0\n" +
+		"0 0 7\n" +
+		"10 20 30\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	img := testLDRImage()
+	path := filepath.Join(t.TempDir(), "out.png")
+	if err := writeImage(path, img); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	decoded, format, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" {
+		t.Errorf("decoded a %q, want a png", format)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Fatalf("got bounds %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+	for y := range 2 {
+		for x := range 2 {
+			got := color.NRGBAModel.Convert(decoded.At(x, y))
+			if want := img.NRGBAAt(x, y); got != want {
+				t.Errorf("pixel (%d, %d) is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand/v2"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// minPrincipledRoughness is the smoothest that a Principled material's
+// specular, clear coat, and glass lobes get. Ray.Color can only weigh light
+// sampling against a material that it could aim every lobe of at a light, so
+// none of them can be perfect mirrors.
+const minPrincipledRoughness = 0.03
+
+// Principled is a material in the style of Disney's principled BSDF. It layers
+// a diffuse base with a sheen, a GGX specular lobe, and a clear coat, and it
+// can blend into metal or rough glass, so plastic, car paint, and partly
+// metallic surfaces can all be described with one material. Every parameter
+// other than BaseColor is in the range [0,1].
+type Principled struct {
+	// BaseColor is the color of the diffuse base, the color of metal, or
+	// the tint of glass.
+	BaseColor Texture
+	// Metallic blends from a dielectric to a metal.
+	Metallic float64
+	// Roughness goes from smooth to rough, for the specular and glass lobes.
+	// It's never less than minPrincipledRoughness.
+	Roughness float64
+	// Specular is how much a dielectric reflects looking straight on. 0.5 is
+	// 4%, which is about right for most dielectrics, and also gives glass an
+	// index of refraction of 1.5.
+	Specular float64
+	// Clearcoat is the strength of a second, colorless specular layer on top,
+	// like lacquer, and ClearcoatRoughness is how rough that layer is.
+	Clearcoat          float64
+	ClearcoatRoughness float64
+	// Sheen is the strength of a soft white glow at grazing angles, like on
+	// cloth.
+	Sheen float64
+	// Transmission blends a dielectric from its diffuse base to glass.
+	Transmission float64
+}
+
+// principledLobes is a Principled material worked out at one hit point.
+type principledLobes struct {
+	frame     shadingFrame
+	wo        Vec3
+	baseColor Color
+	roughness float64
+	sheen     float64
+	// f0 is the specular lobe's reflectance looking straight on
+	f0 Color
+	// diffuse, specular, clearcoat, and glass are how much each lobe counts
+	diffuse, specular, clearcoat, glass float64
+	// the chances of sampling each lobe
+	pDiffuse, pSpecular, pClearcoat, pGlass float64
+
+	specularGGX, clearcoatGGX ggx
+	boundary                  roughDielectric
+}
+
+func (p Principled) lobes(record HitRecord) principledLobes {
+	for _, parameter := range []float64{p.Metallic, p.Roughness, p.Specular, p.Clearcoat, p.ClearcoatRoughness, p.Sheen, p.Transmission} {
+		if parameter < 0 || parameter > 1 {
+			log.Panicf("principled parameters must be in the range [0,1], got %+v", p)
+		}
+	}
+
+	l := principledLobes{
+		frame:     newShadingFrame(record.Normal),
+		baseColor: p.BaseColor.Value(record.U, record.V, record.HitPoint),
+		roughness: p.Roughness,
+		sheen:     p.Sheen,
+	}
+	l.wo = l.frame.toLocal(record.Ray.Direction.UnitVector().Scale(-1))
+
+	dielectricF0 := 0.08 * p.Specular
+	l.f0 = Color{white.Vec.Scale(dielectricF0 * (1 - p.Metallic)).Add(l.baseColor.Vec.Scale(p.Metallic))}
+	l.diffuse = (1 - p.Metallic) * (1 - p.Transmission)
+	l.glass = (1 - p.Metallic) * p.Transmission
+	// glass has its own reflections
+	l.specular = 1 - l.glass
+	// Disney's clear coat is only ever a quarter as strong as the rest.
+	l.clearcoat = p.Clearcoat / 4
+
+	l.specularGGX = newGGX(max(p.Roughness, minPrincipledRoughness))
+	l.clearcoatGGX = newGGX(max(p.ClearcoatRoughness, minPrincipledRoughness))
+	// This is the index of refraction that reflects f0 looking straight on.
+	// Glass has to bend light at least a little, or the light that goes
+	// straight through could never be aimed at.
+	eta := max((1+math.Sqrt(dielectricF0))/(1-math.Sqrt(dielectricF0)), 1.01)
+	if !record.Exterior {
+		eta = 1 / eta
+	}
+	l.boundary = roughDielectric{l.specularGGX, eta}
+
+	if l.wo.Z <= 0 {
+		return l
+	}
+	l.pDiffuse = l.diffuse * (average(l.baseColor) + l.sheen)
+	l.pSpecular = l.specular * average(fresnelSchlick(l.f0, l.wo.Z))
+	l.pClearcoat = l.clearcoat * fresnelSchlick(newColor(0.04, 0.04, 0.04), l.wo.Z).R()
+	l.pGlass = l.glass
+	total := l.pDiffuse + l.pSpecular + l.pClearcoat + l.pGlass
+	if total > 0 {
+		l.pDiffuse /= total
+		l.pSpecular /= total
+		l.pClearcoat /= total
+		l.pGlass /= total
+	}
+	return l
+}
+
+func average(c Color) float64 {
+	return (c.R() + c.G() + c.B()) / 3
+}
+
+// eval is the BSDF times the cosine of wi.
+func (l principledLobes) eval(wi Vec3) Color {
+	var f Color
+	if l.wo.Z <= 0 || wi.Z == 0 {
+		return f
+	}
+	if wi.Z > 0 {
+		h := l.wo.Add(wi).UnitVector()
+		cosine := wi.Dot(h)
+
+		// Burley's diffuse gets darker at grazing angles on smooth surfaces
+		// and brighter on rough ones.
+		fd90 := 0.5 + 2*l.roughness*cosine*cosine
+		retro := (1 + (fd90-1)*math.Pow(1-wi.Z, 5)) * (1 + (fd90-1)*math.Pow(1-l.wo.Z, 5))
+		diffuse := l.baseColor.Vec.Scale(retro / math.Pi)
+		sheen := white.Vec.Scale(l.sheen * math.Pow(1-cosine, 5))
+		f.Vec = f.Vec.Add(diffuse.Add(sheen).Scale(l.diffuse * wi.Z))
+
+		specular := fresnelSchlick(l.f0, cosine).Vec.Scale(l.specularGGX.d(h) * l.specularGGX.g2(l.wo, wi) / (4 * l.wo.Z))
+		f.Vec = f.Vec.Add(specular.Scale(l.specular))
+
+		clearcoat := fresnelSchlick(newColor(0.04, 0.04, 0.04), cosine).R() * l.clearcoatGGX.d(h) * l.clearcoatGGX.g2(l.wo, wi) / (4 * l.wo.Z)
+		f.Vec = f.Vec.Add(white.Vec.Scale(l.clearcoat * clearcoat))
+	}
+	if l.glass > 0 {
+		// The square root tints light by the base color once it's gone in
+		// and come back out.
+		tint := white.Vec
+		if wi.Z < 0 {
+			tint = vec.New(math.Sqrt(l.baseColor.R()), math.Sqrt(l.baseColor.G()), math.Sqrt(l.baseColor.B()))
+		}
+		f.Vec = f.Vec.Add(tint.Scale(l.glass * l.boundary.eval(l.wo, wi)))
+	}
+	return f
+}
+
+// pdf is the density of sample picking wi.
+func (l principledLobes) pdf(wi Vec3) float64 {
+	var pdf float64
+	if wi.Z > 0 {
+		pdf += l.pDiffuse * wi.Z / math.Pi
+		pdf += l.pSpecular * l.specularGGX.reflectionPDF(l.wo, wi)
+		pdf += l.pClearcoat * l.clearcoatGGX.reflectionPDF(l.wo, wi)
+	}
+	if l.pGlass > 0 {
+		pdf += l.pGlass * l.boundary.pdf(l.wo, wi)
+	}
+	return pdf
+}
+
+// sample picks a lobe and then a direction from it. ok is false if the lobe
+// didn't scatter.
+func (l principledLobes) sample(rng *rand.Rand) (wi Vec3, ok bool) {
+	if l.wo.Z <= 0 {
+		return Vec3{}, false
+	}
+	u := rng.Float64()
+	switch {
+	case u < l.pDiffuse:
+		// cosine weighted, as for Lambertian
+		wi = vec.New(0, 0, 1).Add(vec.RandomUnit(rng))
+		if vec.IsNearZero(wi) {
+			return vec.New(0, 0, 1), true
+		}
+		return wi.UnitVector(), true
+	case u < l.pDiffuse+l.pSpecular:
+		h := l.specularGGX.sampleVisibleNormal(l.wo, rng)
+		wi = reflect(l.wo.Scale(-1), h)
+		return wi, wi.Z > 0
+	case u < l.pDiffuse+l.pSpecular+l.pClearcoat:
+		h := l.clearcoatGGX.sampleVisibleNormal(l.wo, rng)
+		wi = reflect(l.wo.Scale(-1), h)
+		return wi, wi.Z > 0
+	case l.pGlass > 0:
+		return l.boundary.sample(l.wo, rng)
+	}
+	// nothing is scattered
+	return Vec3{}, false
+}
+
+// Scatter picks one lobe but attenuates by every lobe's share of the light
+// that goes the way it picked, so that any lobe could've picked it.
+func (p Principled) Scatter(record HitRecord, rng *rand.Rand) (scattered bool, scatteredRay Ray, attenuation Color) {
+	l := p.lobes(record)
+	wi, ok := l.sample(rng)
+	if !ok {
+		return false, Ray{}, Color{}
+	}
+	pdf := l.pdf(wi)
+	if pdf <= 0 {
+		return false, Ray{}, Color{}
+	}
+	newRay := Ray{record.HitPoint, l.frame.toWorld(wi), record.Ray.Time}
+	return true, newRay, Color{l.eval(wi).Vec.Divide(pdf)}
+}
+
+func (p Principled) Eval(record HitRecord, direction Vec3) Color {
+	l := p.lobes(record)
+	return l.eval(l.frame.toLocal(direction))
+}
+
+func (p Principled) PDF(record HitRecord, direction Vec3) float64 {
+	l := p.lobes(record)
+	return l.pdf(l.frame.toLocal(direction))
+}
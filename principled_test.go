@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestPrincipledScatterMatchesEval(t *testing.T) {
+	orange := newColor(0.9, 0.5, 0.1)
+	materials := map[string]Principled{
+		"plastic":       {BaseColor: orange, Roughness: 0.4, Specular: 0.5},
+		"coated paint":  {BaseColor: orange, Roughness: 0.6, Specular: 0.5, Clearcoat: 1, ClearcoatRoughness: 0.3},
+		"cloth":         {BaseColor: orange, Roughness: 1, Sheen: 1},
+		"half metal":    {BaseColor: orange, Metallic: 0.5, Roughness: 0.5, Specular: 0.5},
+		"frosted glass": {BaseColor: white, Roughness: 0.5, Specular: 0.5, Transmission: 1},
+		"tinted glass":  {BaseColor: orange, Roughness: 0.3, Specular: 0.5, Transmission: 0.7},
+	}
+	for name, material := range materials {
+		t.Run(name, func(t *testing.T) {
+			checkScatterMatchesEval(t, material, obliqueHit())
+		})
+	}
+}
+
+func TestPrincipledMetalMatchesMetal(t *testing.T) {
+	record := obliqueHit()
+	albedo := newColor(0.9, 0.6, 0.3)
+	principled := Principled{BaseColor: albedo, Metallic: 1, Roughness: 0.4, Specular: 0.5, Sheen: 1}
+	metal := Metal{Albedo: albedo, Roughness: 0.4}
+	rng := rand.New(rand.NewPCG(29, 30))
+	for range 1000 {
+		direction := vec.RandomUnit(rng)
+		if got, want := principled.Eval(record, direction), metal.Eval(record, direction); got.Vec.Subtract(want.Vec).Length() > 1e-9 {
+			t.Fatalf("expected %v going %v, got %v", want, direction, got)
+		}
+		if got, want := principled.PDF(record, direction), metal.PDF(record, direction); math.Abs(got-want) > 1e-9*want {
+			t.Fatalf("expected a PDF of %v going %v, got %v", want, direction, got)
+		}
+	}
+}
+
+func TestPrincipledGlassLetsLightThrough(t *testing.T) {
+	// Looking straight through clear glass, all but about 4% gets in.
+	record := NewHitRecord(Ray{Origin: vec.New(0, 1, 0), Direction: vec.New(0, -1, 0)}, 1, vec.New(0, 1, 0), vec.New(0, 0, 0), nil)
+	glass := Principled{BaseColor: white, Specular: 0.5, Transmission: 1}
+	rng := rand.New(rand.NewPCG(31, 32))
+	const samples = 10000
+	var transmitted, reflected float64
+	for range samples {
+		ok, ray, attenuation := glass.Scatter(record, rng)
+		if !ok {
+			continue
+		}
+		if ray.Direction.Y < 0 {
+			transmitted += attenuation.R()
+		} else {
+			reflected += attenuation.R()
+		}
+	}
+	if transmitted /= samples; math.Abs(transmitted-0.96) > 0.01 {
+		t.Errorf("expected about 96%% of light to get through, got %v", transmitted)
+	}
+	if reflected /= samples; math.Abs(reflected-0.04) > 0.01 {
+		t.Errorf("expected about 4%% of light to be reflected, got %v", reflected)
+	}
+}
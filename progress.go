@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Progress is a snapshot of how far along a render is.
+type Progress struct {
+	// TilesDone and TotalTiles count tiles from every pass over the image.
+	TilesDone  int
+	TotalTiles int
+	Elapsed    time.Duration
+	// ETA is an estimate of how much longer the render will take, based on how
+	// long the tiles that are done took and any deadline on the render.
+	ETA time.Duration
+	// Stats covers the tiles that are done so far. When Finished is true, it's
+	// the summary of the whole render.
+	Stats RenderStats
+	// Finished is true for the last report, which comes after the render is
+	// done or has been stopped early.
+	Finished bool
+}
+
+func (p Progress) Percent() float64 {
+	if p.TotalTiles == 0 {
+		return 100
+	}
+	return 100 * float64(p.TilesDone) / float64(p.TotalTiles)
+}
+
+// RenderStats counts the rays traced during a render.
+type RenderStats struct {
+	// PrimaryRays are the rays that start at the camera
+	PrimaryRays uint64
+	// Bounces is the number of rays that were scattered by a surface
+	Bounces  uint64
+	Duration time.Duration
+	// DepthHistogram[d] is the number of paths that bounced d times before
+	// they were absorbed, escaped, or ended by Russian roulette. The last
+	// bucket counts paths that were cut off by MaxBounces.
+	DepthHistogram []uint64
+}
+
+func newRenderStats(maxBounces int) RenderStats {
+	return RenderStats{DepthHistogram: make([]uint64, maxBounces+1)}
+}
+
+// addPath records one path from the camera that bounced the given number of
+// times.
+func (s *RenderStats) addPath(bounces int) {
+	s.PrimaryRays++
+	s.Bounces += uint64(bounces)
+	s.DepthHistogram[bounces]++
+}
+
+// merge adds the counts from other into s. Both must have the same number of
+// histogram buckets.
+func (s *RenderStats) merge(other RenderStats) {
+	s.PrimaryRays += other.PrimaryRays
+	s.Bounces += other.Bounces
+	for i, count := range other.DepthHistogram {
+		s.DepthHistogram[i] += count
+	}
+}
+
+// RaysPerSecond is the number of primary rays and bounces traced per second.
+func (s RenderStats) RaysPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.PrimaryRays+s.Bounces) / s.Duration.Seconds()
+}
+
+// String summarizes the stats over multiple lines, including a bar chart of
+// the depth histogram.
+func (s RenderStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Rendered in %v\n", s.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&b, "Primary rays: %d\n", s.PrimaryRays)
+	fmt.Fprintf(&b, "Bounces: %d\n", s.Bounces)
+	fmt.Fprintf(&b, "Rays/sec: %.0f\n", s.RaysPerSecond())
+	if s.PrimaryRays == 0 {
+		return b.String()
+	}
+
+	fmt.Fprintln(&b, "Path depths:")
+	const barWidth = 40
+	maxBounces := len(s.DepthHistogram) - 1
+	for depth, count := range s.DepthHistogram {
+		if count == 0 {
+			continue
+		}
+		fraction := float64(count) / float64(s.PrimaryRays)
+		bar := strings.Repeat("#", int(fraction*barWidth+0.5))
+		fmt.Fprintf(&b, "%4d: %10d %6.2f%% %s", depth, count, 100*fraction, bar)
+		if depth == maxBounces {
+			b.WriteString(" (hit MaxBounces)")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// logProgress is the default way of reporting progress. It overwrites the
+// same line of c.Log until the render is done and then writes a summary.
+func (c camera) logProgress(p Progress) {
+	if p.Finished {
+		fmt.Fprint(c.Log, "\r                                                  \r")
+		if p.TilesDone < p.TotalTiles {
+			fmt.Fprintf(c.Log, "Stopped early at %.1f%%\n", p.Percent())
+		}
+		fmt.Fprint(c.Log, p.Stats)
+		return
+	}
+	fmt.Fprintf(
+		c.Log, "\r%5.1f%% | elapsed %v | ETA %v     ",
+		p.Percent(), p.Elapsed.Round(time.Second), p.ETA.Round(time.Second),
+	)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// Plane is a flat surface that goes on forever. It's a better floor than a
+// huge sphere, which curves away at the horizon.
+type Plane struct {
+	// Point is any point on the plane
+	Point Vec3
+	// Normal is the outward normal of the plane. It must be a unit vector.
+	Normal   Vec3
+	Material Material
+}
+
+func (p Plane) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	denominator := p.Normal.Dot(ray.Direction)
+	if math.Abs(denominator) < 1e-12 {
+		// the ray is parallel to the plane
+		return false, HitRecord{}
+	}
+	t := p.Normal.Dot(p.Point.Subtract(ray.Origin)) / denominator
+	if t <= tMin || tMax <= t {
+		return false, HitRecord{}
+	}
+
+	hitPoint := ray.At(t)
+	// Snapping the hit point exactly onto an axis-aligned plane stops textures
+	// like CheckerTexture from speckling when the plane lies on a boundary
+	// between checkers, as a floor at y = 0 does.
+	switch {
+	case p.Normal.X == 0 && p.Normal.Z == 0:
+		hitPoint.Y = p.Point.Y
+	case p.Normal.Y == 0 && p.Normal.Z == 0:
+		hitPoint.X = p.Point.X
+	case p.Normal.X == 0 && p.Normal.Y == 0:
+		hitPoint.Z = p.Point.Z
+	}
+	record := NewHitRecord(ray, t, p.Normal, hitPoint, p.Material)
+	// Texture coordinates repeat every unit along two directions in the
+	// plane, so an image texture tiles across it.
+	tangent, bitangent := vec.Basis(p.Normal)
+	offset := hitPoint.Subtract(p.Point)
+	record.U = fract(offset.Dot(tangent))
+	record.V = fract(offset.Dot(bitangent))
+	return true, record
+}
+
+// BoundingBox is infinite along every axis that the plane isn't perpendicular
+// to.
+func (p Plane) BoundingBox() AABB {
+	box := AABB{
+		Min: vec.New(math.Inf(-1), math.Inf(-1), math.Inf(-1)),
+		Max: vec.New(math.Inf(1), math.Inf(1), math.Inf(1)),
+	}
+	switch {
+	case p.Normal.X == 0 && p.Normal.Z == 0:
+		box.Min.Y, box.Max.Y = p.Point.Y, p.Point.Y
+	case p.Normal.Y == 0 && p.Normal.Z == 0:
+		box.Min.X, box.Max.X = p.Point.X, p.Point.X
+	case p.Normal.X == 0 && p.Normal.Y == 0:
+		box.Min.Z, box.Max.Z = p.Point.Z, p.Point.Z
+	}
+	return box.padded(1e-4)
+}
+
+// fract returns the fractional part of x, which is always in [0,1).
+func fract(x float64) float64 {
+	return x - math.Floor(x)
+}
+
+// Quad is a parallelogram with corners Q, Q+U, Q+V, and Q+U+V. Its outward
+// normal points towards whoever sees U turn counter-clockwise onto V.
+type Quad struct {
+	Q        Vec3
+	U        Vec3
+	V        Vec3
+	Material Material
+}
+
+func (q Quad) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	n := q.U.Cross(q.V)
+	normal := n.UnitVector()
+	denominator := normal.Dot(ray.Direction)
+	if math.Abs(denominator) < 1e-12 {
+		// the ray is parallel to the quad
+		return false, HitRecord{}
+	}
+	t := normal.Dot(q.Q.Subtract(ray.Origin)) / denominator
+	if t <= tMin || tMax <= t {
+		return false, HitRecord{}
+	}
+
+	// alpha and beta are how far along U and V the hit point is. It's only
+	// inside the quad if both are in [0,1].
+	hitPoint := ray.At(t)
+	planar := hitPoint.Subtract(q.Q)
+	w := n.Divide(n.Dot(n))
+	alpha := w.Dot(planar.Cross(q.V))
+	beta := w.Dot(q.U.Cross(planar))
+	if alpha < 0 || alpha > 1 || beta < 0 || beta > 1 {
+		return false, HitRecord{}
+	}
+
+	record := NewHitRecord(ray, t, normal, hitPoint, q.Material)
+	record.U, record.V = alpha, beta
+	return true, record
+}
+
+func (q Quad) BoundingBox() AABB {
+	box := NewAABB(q.Q, q.Q.Add(q.U).Add(q.V))
+	box = box.Union(NewAABB(q.Q.Add(q.U), q.Q.Add(q.V)))
+	return box.padded(1e-4)
+}
+
+// NewBox returns the six sides of the axis-aligned box with opposite corners a
+// and b. Their normals all point out of the box.
+func NewBox(a, b Vec3, material Material) World {
+	min := vec.Min(a, b)
+	max := vec.Max(a, b)
+	dx := vec.New(max.X-min.X, 0, 0)
+	dy := vec.New(0, max.Y-min.Y, 0)
+	dz := vec.New(0, 0, max.Z-min.Z)
+	return World{
+		// front
+		Quad{vec.New(min.X, min.Y, max.Z), dx, dy, material},
+		// right
+		Quad{vec.New(max.X, min.Y, max.Z), dz.Scale(-1), dy, material},
+		// back
+		Quad{vec.New(max.X, min.Y, min.Z), dx.Scale(-1), dy, material},
+		// left
+		Quad{vec.New(min.X, min.Y, min.Z), dz, dy, material},
+		// top
+		Quad{vec.New(min.X, max.Y, max.Z), dx, dz.Scale(-1), material},
+		// bottom
+		Quad{vec.New(min.X, min.Y, min.Z), dx, dz, material},
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestQuadHit(t *testing.T) {
+	quad := Quad{vec.New(0, 0, 0), vec.New(2, 0, 0), vec.New(0, 1, 0), Lambertian{white}}
+	tests := []struct {
+		name   string
+		ray    Ray
+		hit    bool
+		t      float64
+		normal Vec3
+		u, v   float64
+	}{
+		{"front", Ray{Origin: vec.New(1, 0.5, 1), Direction: vec.New(0, 0, -1)}, true, 1, vec.New(0, 0, 1), 0.5, 0.5},
+		{"back", Ray{Origin: vec.New(0.5, 0.25, -2), Direction: vec.New(0, 0, 1)}, true, 2, vec.New(0, 0, -1), 0.25, 0.25},
+		{"outside", Ray{Origin: vec.New(2.5, 0.5, 1), Direction: vec.New(0, 0, -1)}, false, 0, Vec3{}, 0, 0},
+		{"parallel", Ray{Origin: vec.New(1, 0.5, 1), Direction: vec.New(1, 0, 0)}, false, 0, Vec3{}, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hit, record := quad.Hit(test.ray, 0.001, math.Inf(1), nil)
+			if hit != test.hit {
+				t.Fatalf("expected hit to be %v", test.hit)
+			}
+			if !hit {
+				return
+			}
+			if math.Abs(record.T-test.t) > 1e-9 {
+				t.Errorf("expected t=%v, got %v", test.t, record.T)
+			}
+			if record.Normal != test.normal {
+				t.Errorf("expected normal %v, got %v", test.normal, record.Normal)
+			}
+			if math.Abs(record.U-test.u) > 1e-9 || math.Abs(record.V-test.v) > 1e-9 {
+				t.Errorf("expected (u, v) = (%v, %v), got (%v, %v)", test.u, test.v, record.U, record.V)
+			}
+		})
+	}
+}
+
+func TestBoxNormalsPointOut(t *testing.T) {
+	box := NewBox(vec.New(1, 2, 3), vec.New(-1, -2, -3), Lambertian{white})
+	center := vec.New(0, 0, 0)
+	for _, direction := range []Vec3{
+		vec.New(1, 0, 0), vec.New(-1, 0, 0),
+		vec.New(0, 1, 0), vec.New(0, -1, 0),
+		vec.New(0, 0, 1), vec.New(0, 0, -1),
+	} {
+		// shoot a ray at each side from outside the box
+		ray := Ray{Origin: center.Add(direction.Scale(10)), Direction: direction.Scale(-1)}
+		hit, record := box.Hit(ray, 0.001, math.Inf(1), nil)
+		if !hit {
+			t.Fatalf("ray from %v missed the box", direction)
+		}
+		if !record.Exterior || record.Normal != direction {
+			t.Errorf("ray from %v hit the outside: %v with normal %v", direction, record.Exterior, record.Normal)
+		}
+	}
+}
+
+func TestPlaneHit(t *testing.T) {
+	plane := Plane{vec.New(0, -0.5, 0), vec.New(0, 1, 0), Lambertian{white}}
+	hit, record := plane.Hit(Ray{Origin: vec.New(100, 1.5, -40), Direction: vec.New(0, -1, 0)}, 0.001, math.Inf(1), nil)
+	if !hit || math.Abs(record.T-2) > 1e-9 || record.Normal != vec.New(0, 1, 0) {
+		t.Errorf("expected to hit the top of the plane at t=2, got %v %+v", hit, record)
+	}
+	if record.HitPoint.Y != -0.5 {
+		t.Errorf("expected the hit point to be exactly on the plane, got %v", record.HitPoint)
+	}
+	if hit, _ := plane.Hit(Ray{Origin: vec.New(0, 1, 0), Direction: vec.New(0, 1, 0)}, 0.001, math.Inf(1), nil); hit {
+		t.Error("a ray going away from the plane shouldn't hit it")
+	}
+}
+
+func TestBVHWithPlaneMatchesWorld(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	world := World{Plane{vec.New(0, -1, 0), vec.New(0, 1, 0), Lambertian{white}}}
+	for range 50 {
+		corner := vec.RandomRange(rng, -10, 10)
+		world = append(world, NewBox(corner, corner.Add(vec.Random(rng)), Lambertian{white}))
+	}
+	bvh := NewBVH(world)
+
+	for range 2000 {
+		ray := Ray{Origin: vec.RandomRange(rng, -15, 15), Direction: vec.RandomUnit(rng)}
+		worldHit, worldRecord := world.Hit(ray, 0.001, math.Inf(1), nil)
+		bvhHit, bvhRecord := bvh.Hit(ray, 0.001, math.Inf(1), nil)
+		if worldHit != bvhHit {
+			t.Fatalf("ray %+v: world hit is %v but BVH hit is %v", ray, worldHit, bvhHit)
+		}
+		if worldHit && worldRecord.T != bvhRecord.T {
+			t.Fatalf("ray %+v: world hit at t=%v but BVH hit at t=%v", ray, worldRecord.T, bvhRecord.T)
+		}
+	}
+}
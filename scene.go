@@ -0,0 +1,811 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/noise"
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+// sceneFile is the JSON description of a scene that can be loaded with
+// loadScene. Materials are given names so that objects can share them.
+//
+// Example:
+//
+//	{
+//		"camera": {"position": [0, 0, 1], "look_at": [0, 0, -1]},
+//		"materials": {"red": {"type": "lambertian", "albedo": [0.8, 0.1, 0.1]}},
+//		"objects": [{"type": "sphere", "center": [0, 0, -1], "radius": 0.5, "material": "red"}]
+//	}
+type sceneFile struct {
+	Camera    sceneCamera              `json:"camera"`
+	Materials map[string]sceneMaterial `json:"materials"`
+	Objects   []sceneObject            `json:"objects"`
+	// Lights are the lights that aren't objects. Objects that give off light
+	// are found automatically.
+	Lights []sceneLight `json:"lights"`
+}
+
+// sceneCamera mirrors CameraOpts. Fields that are left out get the same
+// defaults as NewCamera gives them.
+type sceneCamera struct {
+	Width              int              `json:"width"`
+	AspectRatio        float64          `json:"aspect_ratio"`
+	VerticalFOVDegrees float64          `json:"vertical_fov"`
+	SamplesPerPixel    int              `json:"samples_per_pixel"`
+	MaxBounces         int              `json:"max_bounces"`
+	Position           jsonVec          `json:"position"`
+	LookAt             jsonVec          `json:"look_at"`
+	Up                 *jsonVec         `json:"up"`
+	FocusDist          float64          `json:"focus_dist"`
+	DefocusAngle       float64          `json:"defocus_angle"`
+	Background         *sceneBackground `json:"background"`
+	ShutterOpen        float64          `json:"shutter_open"`
+	ShutterClose       float64          `json:"shutter_close"`
+}
+
+// sceneBackground is either a plain color written as an array, or an object
+// with a Type of "gradient" or "environment".
+type sceneBackground struct {
+	color *jsonVec
+	Type  string `json:"type"`
+	// Bottom and Top describe a gradient.
+	Bottom jsonVec `json:"bottom"`
+	Top    jsonVec `json:"top"`
+	// Path is the equirectangular .hdr, PNG, or JPEG file of an environment
+	// map. It's relative to the scene file. Rotation turns it around the Y
+	// axis in degrees, and Intensity defaults to 1.
+	Path      string   `json:"path"`
+	Rotation  float64  `json:"rotation"`
+	Intensity *float64 `json:"intensity"`
+}
+
+type sceneLight struct {
+	// Type is one of "point", "spot", or "directional"
+	Type string `json:"type"`
+	// Position is where a point or spot light is.
+	Position jsonVec `json:"position"`
+	// Direction is the way a spot light points or a directional light
+	// travels.
+	Direction jsonVec `json:"direction"`
+	// Intensity is for point and spot lights, and Irradiance is for
+	// directional lights.
+	Intensity  jsonVec `json:"intensity"`
+	Irradiance jsonVec `json:"irradiance"`
+	// InnerAngle and OuterAngle are the degrees from Direction where a spot
+	// light starts to fade and where it's gone.
+	InnerAngle float64 `json:"inner_angle"`
+	OuterAngle float64 `json:"outer_angle"`
+	// AngularDiameter is how many degrees across a directional light looks.
+	AngularDiameter float64 `json:"angular_diameter"`
+}
+
+type sceneMaterial struct {
+	// Type is one of "principled", "lambertian", "metal", "dielectric",
+	// "diffuse_light", or "isotropic". It defaults to "principled".
+	Type      string        `json:"type"`
+	Albedo    *sceneTexture `json:"albedo"`
+	Roughness float64       `json:"roughness"`
+	// Fuzz is what Roughness was called before metals were microfacet
+	// conductors. It's still read so that older scene files load.
+	Fuzz *float64 `json:"fuzz"`
+	// Conductor is the name of a metal whose measured index of refraction
+	// gives its color: "gold", "copper", or "aluminium". Albedo tints it and
+	// defaults to white.
+	Conductor       string  `json:"conductor"`
+	RefractionIndex float64 `json:"refraction_index"`
+	// AbsorptionColor is the color that white light turns after it goes
+	// AbsorptionDistance through a dielectric, which defaults to 1. Without
+	// it, a dielectric is clear.
+	AbsorptionColor    *jsonVec `json:"absorption_color"`
+	AbsorptionDistance float64  `json:"absorption_distance"`
+	Emit               jsonVec  `json:"emit"`
+	// These describe a principled material, along with Albedo as its base
+	// color and Roughness. Specular defaults to 0.5.
+	Metallic           float64  `json:"metallic"`
+	Specular           *float64 `json:"specular"`
+	Clearcoat          float64  `json:"clearcoat"`
+	ClearcoatRoughness float64  `json:"clearcoat_roughness"`
+	Sheen              float64  `json:"sheen"`
+	Transmission       float64  `json:"transmission"`
+}
+
+// sceneTexture is either a plain color written as an array, like [0.8, 0.1,
+// 0.1], or an object with a Type of "checker", "image", "noise", "marble", or
+// "wood".
+type sceneTexture struct {
+	color *jsonVec
+	Type  string `json:"type"`
+	// Scale, Even, and Odd describe a checker texture.
+	Scale float64       `json:"scale"`
+	Even  *sceneTexture `json:"even"`
+	Odd   *sceneTexture `json:"odd"`
+	// Scale, Seed, Octaves, Light, and Dark describe the noise, marble, and
+	// wood textures. Octaves defaults to 7, Light to white, and Dark to
+	// black.
+	Seed    uint64        `json:"seed"`
+	Octaves int           `json:"octaves"`
+	Light   *sceneTexture `json:"light"`
+	Dark    *sceneTexture `json:"dark"`
+	// Path is the PNG or JPEG file of an image texture. It's relative to the
+	// scene file.
+	Path string `json:"path"`
+}
+
+type sceneObject struct {
+	// Type is one of "sphere", "triangle", "plane", "quad", "box", "mesh", or
+	// "constant_medium"
+	Type   string  `json:"type"`
+	Center jsonVec `json:"center"`
+	// Center1 makes a sphere move from Center at time 0 to Center1 at time
+	// 1.
+	Center1  *jsonVec `json:"center1"`
+	Radius   float64  `json:"radius"`
+	Material string   `json:"material"`
+	// Vertices are the corners of a triangle, and Normals are its optional
+	// per-corner normals.
+	Vertices []jsonVec `json:"vertices"`
+	Normals  []jsonVec `json:"normals"`
+	// Point is any point on a plane, and Normal is its outward normal.
+	Point  jsonVec `json:"point"`
+	Normal jsonVec `json:"normal"`
+	// Corner, U, and V describe a quad. See Quad.
+	Corner jsonVec `json:"corner"`
+	U      jsonVec `json:"u"`
+	V      jsonVec `json:"v"`
+	// Min and Max are opposite corners of a box.
+	Min jsonVec `json:"min"`
+	Max jsonVec `json:"max"`
+	// Path is the OBJ file of a mesh. It's relative to the scene file. Material
+	// is optional for a mesh, and is only used for faces that don't get one
+	// from the OBJ's material library.
+	Path string `json:"path"`
+	// Boundary is the shape that a constant_medium fills with a Density, using
+	// Material as its phase function. The boundary's own material can be left
+	// out.
+	Boundary *sceneObject `json:"boundary"`
+	Density  float64      `json:"density"`
+	// Transform is applied to any type of object, one step after another.
+	Transform []sceneTransform `json:"transform"`
+}
+
+// sceneTransform is one step of a transform. Exactly one of its fields must be
+// set. Rotations are in degrees, counter-clockwise around their axis.
+type sceneTransform struct {
+	Translate *jsonVec `json:"translate"`
+	// Scale is a factor for each axis.
+	Scale   *jsonVec `json:"scale"`
+	RotateX *float64 `json:"rotate_x"`
+	RotateY *float64 `json:"rotate_y"`
+	RotateZ *float64 `json:"rotate_z"`
+}
+
+// meshKey identifies a mesh that has been loaded, so that it can be shared by
+// every object that uses it.
+type meshKey struct {
+	path     string
+	material string
+}
+
+// jsonVec is a Vec3 written as a JSON array of three numbers.
+type jsonVec [3]float64
+
+func (t *sceneTexture) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		t.color = new(jsonVec)
+		return json.Unmarshal(data, t.color)
+	}
+
+	// fields is the same as sceneTexture without this UnmarshalJSON method, so
+	// that decoding into it doesn't recurse forever.
+	type fields sceneTexture
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*fields)(t))
+}
+
+func (b *sceneBackground) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		b.color = new(jsonVec)
+		return json.Unmarshal(data, b.color)
+	}
+
+	type fields sceneBackground
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*fields)(b))
+}
+
+func (v jsonVec) vec() Vec3 {
+	return vec.New(v[0], v[1], v[2])
+}
+
+func (v jsonVec) color() Color {
+	return newColor(v[0], v[1], v[2])
+}
+
+// loadSceneFile reads the scene at path. See loadScene.
+func loadSceneFile(path string) (World, CameraOpts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, CameraOpts{}, err
+	}
+	defer f.Close()
+
+	world, opts, err := loadScene(f, filepath.Dir(path))
+	if err != nil {
+		return nil, CameraOpts{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return world, opts, nil
+}
+
+// loadScene decodes a JSON scene (see sceneFile) into the objects in it and the
+// options for the camera that views them. Paths in the scene are relative to
+// dir.
+func loadScene(r io.Reader, dir string) (World, CameraOpts, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	var scene sceneFile
+	if err := decoder.Decode(&scene); err != nil {
+		return nil, CameraOpts{}, err
+	}
+
+	materials := make(map[string]Material, len(scene.Materials))
+	for name, m := range scene.Materials {
+		material, err := m.material(dir)
+		if err != nil {
+			return nil, CameraOpts{}, fmt.Errorf("material %q: %w", name, err)
+		}
+		materials[name] = material
+	}
+
+	world := make(World, 0, len(scene.Objects))
+	meshes := map[meshKey]Hittable{}
+	for i, o := range scene.Objects {
+		object, err := o.transformed(materials, dir, meshes)
+		if err != nil {
+			return nil, CameraOpts{}, fmt.Errorf("object %d: %w", i, err)
+		}
+		world = append(world, object)
+	}
+
+	opts, err := scene.Camera.opts(dir)
+	if err != nil {
+		return nil, CameraOpts{}, fmt.Errorf("camera: %w", err)
+	}
+	world, opts.Lights = MarkLights(world)
+	for i, l := range scene.Lights {
+		light, err := l.light()
+		if err != nil {
+			return nil, CameraOpts{}, fmt.Errorf("light %d: %w", i, err)
+		}
+		opts.Lights = append(opts.Lights, light)
+	}
+	return world, opts, nil
+}
+
+// opts checks the camera's fields and returns them as CameraOpts, so that a bad
+// scene file is an error rather than a panic in NewCamera.
+func (c sceneCamera) opts(dir string) (CameraOpts, error) {
+	if c.Width < 0 {
+		return CameraOpts{}, fmt.Errorf("width cannot be negative, got %d", c.Width)
+	}
+	if c.AspectRatio < 0 {
+		return CameraOpts{}, fmt.Errorf("aspect_ratio cannot be negative, got %v", c.AspectRatio)
+	}
+	if c.VerticalFOVDegrees < 0 || c.VerticalFOVDegrees >= 180 {
+		return CameraOpts{}, fmt.Errorf("vertical_fov must be in the range (0,180), got %v", c.VerticalFOVDegrees)
+	}
+	if c.SamplesPerPixel < 0 {
+		return CameraOpts{}, fmt.Errorf("samples_per_pixel cannot be negative, got %d", c.SamplesPerPixel)
+	}
+	if c.MaxBounces < 0 {
+		return CameraOpts{}, fmt.Errorf("max_bounces cannot be negative, got %d", c.MaxBounces)
+	}
+	if c.FocusDist < 0 {
+		return CameraOpts{}, fmt.Errorf("focus_dist cannot be negative, got %v", c.FocusDist)
+	}
+	if c.DefocusAngle < 0 || c.DefocusAngle >= 180 {
+		return CameraOpts{}, fmt.Errorf("defocus_angle must be in the range [0,180), got %v", c.DefocusAngle)
+	}
+	if c.ShutterClose < c.ShutterOpen {
+		return CameraOpts{}, errors.New("shutter_close cannot be before shutter_open")
+	}
+
+	// A camera at the origin that doesn't say where it looks gets NewCamera's
+	// default look_at, and one that doesn't say which way is up gets its
+	// default up. The camera can't be built if up is along the way it looks.
+	position, lookAt := c.Position.vec(), c.LookAt.vec()
+	if position == lookAt && position != (Vec3{}) {
+		return CameraOpts{}, errors.New("position and look_at cannot be the same")
+	}
+	effectiveLookAt := lookAt
+	if position == lookAt {
+		effectiveLookAt = vec.New(0, 0, -1)
+	}
+	var up Vec3
+	effectiveUp := vec.New(0, 1, 0)
+	if c.Up != nil {
+		up = c.Up.vec()
+		if up == (Vec3{}) {
+			return CameraOpts{}, errors.New("up cannot be zero")
+		}
+		effectiveUp = up
+	}
+	if effectiveUp.Cross(effectiveLookAt.Subtract(position)) == (Vec3{}) {
+		return CameraOpts{}, errors.New("up cannot point the same way as the camera looks")
+	}
+
+	opts := CameraOpts{
+		Width:              c.Width,
+		AspectRatio:        c.AspectRatio,
+		VerticalFOVDegrees: c.VerticalFOVDegrees,
+		SamplesPerPixel:    c.SamplesPerPixel,
+		MaxBounces:         c.MaxBounces,
+		Position:           position,
+		LookAt:             lookAt,
+		Up:                 up,
+		FocusDist:          c.FocusDist,
+		DefocusAngle:       c.DefocusAngle,
+		ShutterOpen:        c.ShutterOpen,
+		ShutterClose:       c.ShutterClose,
+	}
+	if c.Background != nil {
+		background, err := c.Background.background(dir)
+		if err != nil {
+			return CameraOpts{}, fmt.Errorf("background: %w", err)
+		}
+		opts.Background = background
+	}
+	return opts, nil
+}
+
+func (b *sceneBackground) background(dir string) (Background, error) {
+	if b.color != nil {
+		return b.color.color(), nil
+	}
+
+	switch b.Type {
+	case "gradient":
+		return GradientBackground{b.Bottom.color(), b.Top.color()}, nil
+	case "environment":
+		if b.Path == "" {
+			return nil, errors.New("environment must have a path")
+		}
+		env, err := LoadEnvironmentMap(filepath.Join(dir, b.Path))
+		if err != nil {
+			return nil, err
+		}
+		env.Rotation = toRadians(b.Rotation)
+		if b.Intensity != nil {
+			if *b.Intensity < 0 {
+				return nil, fmt.Errorf("environment must have an intensity >= 0, got %v", *b.Intensity)
+			}
+			env.Intensity = *b.Intensity
+		}
+		return env, nil
+	case "":
+		return nil, errors.New("missing type")
+	}
+	return nil, fmt.Errorf("unknown background type %q", b.Type)
+}
+
+func (l sceneLight) light() (Light, error) {
+	switch l.Type {
+	case "point":
+		return PointLight{l.Position.vec(), l.Intensity.color()}, nil
+	case "spot":
+		if l.Direction.vec() == (Vec3{}) {
+			return nil, errors.New("spot must have a direction")
+		}
+		if l.InnerAngle < 0 || l.OuterAngle < l.InnerAngle || l.OuterAngle > 180 {
+			return nil, fmt.Errorf("spot must have 0 <= inner_angle <= outer_angle <= 180, got %v and %v", l.InnerAngle, l.OuterAngle)
+		}
+		return SpotLight{l.Position.vec(), l.Direction.vec(), l.Intensity.color(), l.InnerAngle, l.OuterAngle}, nil
+	case "directional":
+		if l.Direction.vec() == (Vec3{}) {
+			return nil, errors.New("directional must have a direction")
+		}
+		if l.AngularDiameter < 0 || l.AngularDiameter >= 180 {
+			return nil, fmt.Errorf("directional must have an angular_diameter in the range [0,180), got %v", l.AngularDiameter)
+		}
+		return DirectionalLight{l.Direction.vec(), l.Irradiance.color(), l.AngularDiameter}, nil
+	case "":
+		return nil, errors.New("missing type")
+	}
+	return nil, fmt.Errorf("unknown light type %q", l.Type)
+}
+
+var conductors = map[string]ComplexIOR{
+	"gold":      GoldIOR,
+	"copper":    CopperIOR,
+	"aluminium": AluminiumIOR,
+}
+
+func (m sceneMaterial) material(dir string) (Material, error) {
+	if m.Fuzz != nil {
+		if m.Roughness != 0 {
+			return nil, errors.New("fuzz was replaced by roughness, so only give roughness")
+		}
+		m.Roughness = *m.Fuzz
+	}
+	switch m.Type {
+	case "lambertian":
+		albedo, err := m.Albedo.texture(dir)
+		if err != nil {
+			return nil, fmt.Errorf("albedo: %w", err)
+		}
+		return Lambertian{albedo}, nil
+	case "metal":
+		if m.Roughness < 0 || m.Roughness > 1 {
+			return nil, fmt.Errorf("roughness must be in the range [0,1], got %v", m.Roughness)
+		}
+		if m.Conductor == "" {
+			albedo, err := m.Albedo.texture(dir)
+			if err != nil {
+				return nil, fmt.Errorf("albedo: %w", err)
+			}
+			return Metal{Albedo: albedo, Roughness: m.Roughness}, nil
+		}
+		ior, ok := conductors[m.Conductor]
+		if !ok {
+			return nil, fmt.Errorf("unknown conductor %q", m.Conductor)
+		}
+		var tint Texture = white
+		if m.Albedo != nil {
+			var err error
+			tint, err = m.Albedo.texture(dir)
+			if err != nil {
+				return nil, fmt.Errorf("albedo: %w", err)
+			}
+		}
+		return Metal{tint, m.Roughness, &ior}, nil
+	case "dielectric":
+		if m.RefractionIndex <= 0 {
+			return nil, errors.New("dielectric must have a refraction_index > 0")
+		}
+		if m.Roughness < 0 || m.Roughness > 1 {
+			return nil, fmt.Errorf("roughness must be in the range [0,1], got %v", m.Roughness)
+		}
+		absorption, err := m.absorption()
+		if err != nil {
+			return nil, err
+		}
+		return Dielectric{RefractionIndex: m.RefractionIndex, Roughness: m.Roughness, Absorption: absorption}, nil
+	case "diffuse_light":
+		return DiffuseLight{m.Emit.color()}, nil
+	case "isotropic":
+		albedo, err := m.Albedo.texture(dir)
+		if err != nil {
+			return nil, fmt.Errorf("albedo: %w", err)
+		}
+		return Isotropic{albedo}, nil
+	case "principled", "":
+		return m.principled(dir)
+	}
+	return nil, fmt.Errorf("unknown material type %q", m.Type)
+}
+
+// absorption works out the Dielectric.Absorption that turns white light
+// AbsorptionColor over AbsorptionDistance.
+func (m sceneMaterial) absorption() (Color, error) {
+	if m.AbsorptionColor == nil {
+		return black, nil
+	}
+	distance := m.AbsorptionDistance
+	if distance == 0 {
+		distance = 1
+	}
+	if distance < 0 {
+		return black, fmt.Errorf("absorption_distance must be > 0, got %v", distance)
+	}
+	color := m.AbsorptionColor.color()
+	components := []float64{color.R(), color.G(), color.B()}
+	for i, c := range components {
+		if c <= 0 || c > 1 {
+			return black, fmt.Errorf("absorption_color must be in the range (0,1], got %v", *m.AbsorptionColor)
+		}
+		components[i] = -math.Log(c) / distance
+	}
+	return newColor(components[0], components[1], components[2]), nil
+}
+
+func (m sceneMaterial) principled(dir string) (Material, error) {
+	specular := 0.5
+	if m.Specular != nil {
+		specular = *m.Specular
+	}
+	parameters := []struct {
+		name  string
+		value float64
+	}{
+		{"metallic", m.Metallic},
+		{"roughness", m.Roughness},
+		{"specular", specular},
+		{"clearcoat", m.Clearcoat},
+		{"clearcoat_roughness", m.ClearcoatRoughness},
+		{"sheen", m.Sheen},
+		{"transmission", m.Transmission},
+	}
+	for _, p := range parameters {
+		if p.value < 0 || p.value > 1 {
+			return nil, fmt.Errorf("%s must be in the range [0,1], got %v", p.name, p.value)
+		}
+	}
+	albedo, err := m.Albedo.texture(dir)
+	if err != nil {
+		return nil, fmt.Errorf("albedo: %w", err)
+	}
+	return Principled{
+		BaseColor:          albedo,
+		Metallic:           m.Metallic,
+		Roughness:          m.Roughness,
+		Specular:           specular,
+		Clearcoat:          m.Clearcoat,
+		ClearcoatRoughness: m.ClearcoatRoughness,
+		Sheen:              m.Sheen,
+		Transmission:       m.Transmission,
+	}, nil
+}
+
+// texture converts t to a Texture. A missing texture is black.
+func (t *sceneTexture) texture(dir string) (Texture, error) {
+	if t == nil {
+		return black, nil
+	}
+	if t.color != nil {
+		return t.color.color(), nil
+	}
+
+	switch t.Type {
+	case "checker":
+		if t.Scale <= 0 {
+			return nil, fmt.Errorf("checker must have a scale > 0, got %v", t.Scale)
+		}
+		if t.Even == nil || t.Odd == nil {
+			return nil, errors.New("checker must have an even and an odd texture")
+		}
+		even, err := t.Even.texture(dir)
+		if err != nil {
+			return nil, fmt.Errorf("even: %w", err)
+		}
+		odd, err := t.Odd.texture(dir)
+		if err != nil {
+			return nil, fmt.Errorf("odd: %w", err)
+		}
+		return CheckerTexture{t.Scale, even, odd}, nil
+	case "image":
+		if t.Path == "" {
+			return nil, errors.New("image must have a path")
+		}
+		return LoadImageTexture(filepath.Join(dir, t.Path))
+	case "noise", "marble", "wood":
+		return t.noiseTexture(dir)
+	case "":
+		return nil, errors.New("missing type")
+	}
+	return nil, fmt.Errorf("unknown texture type %q", t.Type)
+}
+
+// noiseTexture converts the noise, marble, and wood types of texture.
+func (t *sceneTexture) noiseTexture(dir string) (Texture, error) {
+	if t.Scale <= 0 {
+		return nil, fmt.Errorf("%s must have a scale > 0, got %v", t.Type, t.Scale)
+	}
+	octaves := t.Octaves
+	if octaves == 0 {
+		octaves = 7
+	}
+	if octaves < 0 {
+		return nil, fmt.Errorf("%s must have octaves > 0, got %v", t.Type, t.Octaves)
+	}
+	var light, dark Texture = white, black
+	var err error
+	if t.Light != nil {
+		if light, err = t.Light.texture(dir); err != nil {
+			return nil, fmt.Errorf("light: %w", err)
+		}
+	}
+	if t.Dark != nil {
+		if dark, err = t.Dark.texture(dir); err != nil {
+			return nil, fmt.Errorf("dark: %w", err)
+		}
+	}
+
+	perlin := noise.NewPerlin(t.Seed)
+	switch t.Type {
+	case "marble":
+		return MarbleTexture{perlin, t.Scale, octaves, light, dark}, nil
+	case "wood":
+		return WoodTexture{perlin, t.Scale, octaves, light, dark}, nil
+	}
+	return NoiseTexture{perlin, t.Scale, octaves, light, dark}, nil
+}
+
+// transformed converts o to a Hittable and applies its transform. meshes
+// caches the meshes that have been loaded so far.
+func (o sceneObject) transformed(materials map[string]Material, dir string, meshes map[meshKey]Hittable) (Hittable, error) {
+	object, err := o.hittable(materials, dir, meshes)
+	if err != nil || len(o.Transform) == 0 {
+		return object, err
+	}
+
+	transform := vec.Identity()
+	for i, step := range o.Transform {
+		m, err := step.matrix()
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: %w", i, err)
+		}
+		transform = m.Multiply(transform)
+	}
+	if _, ok := transform.Inverse(); !ok {
+		return nil, errors.New("transform squashes the object flat")
+	}
+	// Lights are aimed at where they are in the world, which a Transformed
+	// hides, so a transformed light would quietly never be aimed at.
+	if emitsLight(object) {
+		return nil, errors.New("objects that give off light cannot have a transform; place them where they go instead")
+	}
+	return NewTransformed(object, transform), nil
+}
+
+func (t sceneTransform) matrix() (Mat4, error) {
+	var steps []Mat4
+	if t.Translate != nil {
+		steps = append(steps, vec.Translation(t.Translate.vec()))
+	}
+	if t.Scale != nil {
+		steps = append(steps, vec.Scaling(t.Scale.vec()))
+	}
+	if t.RotateX != nil {
+		steps = append(steps, vec.RotationX(toRadians(*t.RotateX)))
+	}
+	if t.RotateY != nil {
+		steps = append(steps, vec.RotationY(toRadians(*t.RotateY)))
+	}
+	if t.RotateZ != nil {
+		steps = append(steps, vec.RotationZ(toRadians(*t.RotateZ)))
+	}
+	if len(steps) != 1 {
+		return Mat4{}, errors.New("must have exactly one of translate, scale, rotate_x, rotate_y, or rotate_z")
+	}
+	return steps[0], nil
+}
+
+func (o sceneObject) hittable(materials map[string]Material, dir string, meshes map[meshKey]Hittable) (Hittable, error) {
+	switch o.Type {
+	case "sphere":
+		material, err := lookupMaterial(materials, o.Material)
+		if err != nil {
+			return nil, err
+		}
+		if o.Radius <= 0 {
+			return nil, fmt.Errorf("sphere must have a radius > 0, got %v", o.Radius)
+		}
+		if o.Center1 != nil {
+			return MovingSphere{o.Center.vec(), o.Center1.vec(), o.Radius, material}, nil
+		}
+		return Sphere{o.Center.vec(), o.Radius, material}, nil
+	case "triangle":
+		material, err := lookupMaterial(materials, o.Material)
+		if err != nil {
+			return nil, err
+		}
+		if len(o.Vertices) != 3 {
+			return nil, fmt.Errorf("triangle must have 3 vertices, got %d", len(o.Vertices))
+		}
+		tri := Triangle{
+			A:        o.Vertices[0].vec(),
+			B:        o.Vertices[1].vec(),
+			C:        o.Vertices[2].vec(),
+			Material: material,
+		}
+		if len(o.Normals) == 3 {
+			for _, n := range o.Normals {
+				if n.vec() == (Vec3{}) {
+					return nil, errors.New("triangle normals cannot be zero")
+				}
+			}
+			tri.NormalA = o.Normals[0].vec().UnitVector()
+			tri.NormalB = o.Normals[1].vec().UnitVector()
+			tri.NormalC = o.Normals[2].vec().UnitVector()
+		} else if len(o.Normals) != 0 {
+			return nil, fmt.Errorf("triangle must have 0 or 3 normals, got %d", len(o.Normals))
+		}
+		return tri, nil
+	case "plane":
+		material, err := lookupMaterial(materials, o.Material)
+		if err != nil {
+			return nil, err
+		}
+		normal := o.Normal.vec()
+		if normal.LengthSquared() == 0 {
+			return nil, errors.New("plane must have a normal")
+		}
+		return Plane{o.Point.vec(), normal.UnitVector(), material}, nil
+	case "quad":
+		material, err := lookupMaterial(materials, o.Material)
+		if err != nil {
+			return nil, err
+		}
+		quad := Quad{o.Corner.vec(), o.U.vec(), o.V.vec(), material}
+		if quad.U.Cross(quad.V).LengthSquared() == 0 {
+			return nil, errors.New("quad must have u and v that aren't parallel")
+		}
+		return quad, nil
+	case "box":
+		material, err := lookupMaterial(materials, o.Material)
+		if err != nil {
+			return nil, err
+		}
+		min, max := o.Min.vec(), o.Max.vec()
+		if min.X == max.X || min.Y == max.Y || min.Z == max.Z {
+			return nil, errors.New("box must have a min and max that differ along every axis")
+		}
+		return NewBox(min, max, material), nil
+	case "mesh":
+		var material Material
+		if o.Material != "" {
+			var err error
+			if material, err = lookupMaterial(materials, o.Material); err != nil {
+				return nil, err
+			}
+		}
+		if o.Path == "" {
+			return nil, errors.New("mesh must have a path")
+		}
+		key := meshKey{filepath.Join(dir, o.Path), o.Material}
+		if mesh, ok := meshes[key]; ok {
+			return mesh, nil
+		}
+		mesh, err := LoadOBJ(key.path, material)
+		if err != nil {
+			return nil, err
+		}
+		meshes[key] = NewBVH(mesh)
+		return meshes[key], nil
+	case "constant_medium":
+		material, err := lookupMaterial(materials, o.Material)
+		if err != nil {
+			return nil, err
+		}
+		if o.Density <= 0 {
+			return nil, fmt.Errorf("constant_medium must have a density > 0, got %v", o.Density)
+		}
+		if o.Boundary == nil {
+			return nil, errors.New("constant_medium must have a boundary")
+		}
+		boundary := *o.Boundary
+		if boundary.Material == "" {
+			boundary.Material = o.Material
+		}
+		boundaryObject, err := boundary.transformed(materials, dir, meshes)
+		if err != nil {
+			return nil, fmt.Errorf("boundary: %w", err)
+		}
+		return ConstantMedium{boundaryObject, o.Density, material}, nil
+	case "":
+		return nil, errors.New("missing type")
+	}
+	return nil, fmt.Errorf("unknown object type %q", o.Type)
+}
+
+func lookupMaterial(materials map[string]Material, name string) (Material, error) {
+	if name == "" {
+		return nil, errors.New("missing material")
+	}
+	material, ok := materials[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown material %q", name)
+	}
+	return material, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestLoadSceneFile(t *testing.T) {
+	world, opts, err := loadSceneFile("scenes/simple.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(world) != 5 {
+		t.Errorf("expected 5 objects, got %d", len(world))
+	}
+	if opts.FocusDist != 3.4 {
+		t.Errorf("expected a focus distance of 3.4, got %v", opts.FocusDist)
+	}
+	if opts.Background != nil {
+		t.Errorf("expected the default background, got %v", opts.Background)
+	}
+}
+
+func TestLoadSceneErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		scene string
+		err   string
+	}{
+		{
+			"unknown field",
+			`{"camera": {"fov": 20}}`,
+			`unknown field "fov"`,
+		},
+		{
+			"unknown object type",
+			`{"objects": [{"type": "cube"}]}`,
+			`object 0: unknown object type "cube"`,
+		},
+		{
+			"unknown material",
+			`{"objects": [{"type": "sphere", "radius": 1, "material": "gold"}]}`,
+			`object 0: unknown material "gold"`,
+		},
+		{
+			"bad roughness",
+			`{"materials": {"m": {"type": "metal", "roughness": 2}}}`,
+			`material "m": roughness must be in the range [0,1]`,
+		},
+		{
+			"fuzz and roughness",
+			`{"materials": {"m": {"type": "metal", "fuzz": 0.2, "roughness": 0.2}}}`,
+			`material "m": fuzz was replaced by roughness`,
+		},
+		{
+			"bad metallic",
+			`{"materials": {"m": {"albedo": [1, 1, 1], "metallic": 2}}}`,
+			`material "m": metallic must be in the range [0,1]`,
+		},
+		{
+			"bad absorption",
+			`{"materials": {"m": {"type": "dielectric", "refraction_index": 1.5, "absorption_color": [1, 0, 0.5]}}}`,
+			`material "m": absorption_color must be in the range (0,1]`,
+		},
+		{
+			"unknown conductor",
+			`{"materials": {"m": {"type": "metal", "conductor": "brass"}}}`,
+			`material "m": unknown conductor "brass"`,
+		},
+		{
+			"bad radius",
+			`{"materials": {"m": {"type": "lambertian"}}, "objects": [{"type": "sphere", "material": "m"}]}`,
+			`object 0: sphere must have a radius > 0`,
+		},
+		{
+			"zero triangle normal",
+			`{"materials": {"m": {"type": "lambertian"}}, "objects": [{"type": "triangle", "vertices": [[0, 0, 0], [1, 0, 0], [0, 1, 0]], "normals": [[0, 0, 1], [0, 0, 0], [0, 0, 1]], "material": "m"}]}`,
+			`object 0: triangle normals cannot be zero`,
+		},
+		{
+			"transformed light",
+			`{"materials": {"lamp": {"type": "diffuse_light", "emit": [4, 4, 4]}}, "objects": [{"type": "sphere", "radius": 1, "material": "lamp", "transform": [{"translate": [0, 3, 0]}]}]}`,
+			`object 0: objects that give off light cannot have a transform`,
+		},
+		{
+			"flat box",
+			`{"materials": {"m": {"type": "lambertian"}}, "objects": [{"type": "box", "min": [0, 0, 0], "max": [1, 0, 1], "material": "m"}]}`,
+			`object 0: box must have a min and max that differ along every axis`,
+		},
+		{
+			"degenerate quad",
+			`{"materials": {"m": {"type": "lambertian"}}, "objects": [{"type": "quad", "u": [1, 0, 0], "v": [2, 0, 0], "material": "m"}]}`,
+			`object 0: quad must have u and v that aren't parallel`,
+		},
+		{
+			"bad marble scale",
+			`{"materials": {"m": {"type": "lambertian", "albedo": {"type": "marble", "light": [1, 1, 1]}}}}`,
+			`material "m": albedo: marble must have a scale > 0`,
+		},
+		{
+			"unknown light type",
+			`{"lights": [{"type": "area"}]}`,
+			`light 0: unknown light type "area"`,
+		},
+		{
+			"bad spot angles",
+			`{"lights": [{"type": "spot", "direction": [0, -1, 0], "inner_angle": 40, "outer_angle": 30}]}`,
+			`light 0: spot must have 0 <= inner_angle <= outer_angle <= 180`,
+		},
+		{
+			"sun without a direction",
+			`{"lights": [{"type": "directional", "irradiance": [1, 1, 1]}]}`,
+			`light 0: directional must have a direction`,
+		},
+		{
+			"bad vertical fov",
+			`{"camera": {"vertical_fov": 200}}`,
+			`camera: vertical_fov must be in the range (0,180)`,
+		},
+		{
+			"negative samples",
+			`{"camera": {"samples_per_pixel": -3}}`,
+			`camera: samples_per_pixel cannot be negative`,
+		},
+		{
+			"negative width",
+			`{"camera": {"width": -1}}`,
+			`camera: width cannot be negative`,
+		},
+		{
+			"negative aspect ratio",
+			`{"camera": {"aspect_ratio": -1.5}}`,
+			`camera: aspect_ratio cannot be negative`,
+		},
+		{
+			"negative max bounces",
+			`{"camera": {"max_bounces": -1}}`,
+			`camera: max_bounces cannot be negative`,
+		},
+		{
+			"negative focus distance",
+			`{"camera": {"focus_dist": -2}}`,
+			`camera: focus_dist cannot be negative`,
+		},
+		{
+			"bad defocus angle",
+			`{"camera": {"defocus_angle": 180}}`,
+			`camera: defocus_angle must be in the range [0,180)`,
+		},
+		{
+			"position at look_at",
+			`{"camera": {"position": [1, 2, 3], "look_at": [1, 2, 3]}}`,
+			`camera: position and look_at cannot be the same`,
+		},
+		{
+			"zero up",
+			`{"camera": {"up": [0, 0, 0]}}`,
+			`camera: up cannot be zero`,
+		},
+		{
+			"up along the view",
+			`{"camera": {"position": [0, 5, 0], "look_at": [0, 0, 0], "up": [0, 1, 0]}}`,
+			`camera: up cannot point the same way as the camera looks`,
+		},
+		{
+			"looking up with the default up",
+			`{"camera": {"look_at": [0, 1, 0]}}`,
+			`camera: up cannot point the same way as the camera looks`,
+		},
+		{
+			"looking down with the default up",
+			`{"camera": {"position": [0, 5, 0], "look_at": [0, 0, 0]}}`,
+			`camera: up cannot point the same way as the camera looks`,
+		},
+		{
+			"shutter closes before it opens",
+			`{"camera": {"shutter_open": 1, "shutter_close": 0.5}}`,
+			`camera: shutter_close cannot be before shutter_open`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := loadScene(strings.NewReader(test.scene), ".")
+			if err == nil {
+				t.Fatalf("expected an error containing %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error containing %q, got %q", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadSceneFuzzIsRoughness(t *testing.T) {
+	// from before metals had a roughness
+	scene := `{
+		"materials": {"chrome": {"type": "metal", "albedo": [0.8, 0.8, 0.8], "fuzz": 0.3}},
+		"objects": [{"type": "sphere", "radius": 1, "material": "chrome"}]
+	}`
+	world, _, err := loadScene(strings.NewReader(scene), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := world[0].(Sphere).Material.(Metal).Roughness; got != 0.3 {
+		t.Errorf("expected fuzz to be read as a roughness of 0.3, got %v", got)
+	}
+}
+
+func TestLoadScenePrincipled(t *testing.T) {
+	scene := `{
+		"materials": {"paint": {"albedo": [0.8, 0.1, 0.1], "roughness": 0.4, "clearcoat": 1}},
+		"objects": [{"type": "sphere", "radius": 1, "material": "paint"}]
+	}`
+	world, _, err := loadScene(strings.NewReader(scene), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Principled{BaseColor: newColor(0.8, 0.1, 0.1), Roughness: 0.4, Specular: 0.5, Clearcoat: 1}
+	if got := world[0].(Sphere).Material; got != want {
+		t.Errorf("expected a material without a type to be %+v, got %+v", want, got)
+	}
+}
+
+func TestLoadSceneDielectric(t *testing.T) {
+	scene := `{
+		"materials": {"bottle": {"type": "dielectric", "refraction_index": 1.5, "roughness": 0.2, "absorption_color": [1, 0.5, 0.25], "absorption_distance": 2}},
+		"objects": [{"type": "sphere", "radius": 1, "material": "bottle"}]
+	}`
+	world, _, err := loadScene(strings.NewReader(scene), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	glass := world[0].(Sphere).Material.(Dielectric)
+	if glass.Roughness != 0.2 {
+		t.Errorf("expected a roughness of 0.2, got %v", glass.Roughness)
+	}
+	// going 2 units through the glass should turn white light the absorption
+	// color
+	for i, want := range []float64{1, 0.5, 0.25} {
+		if got := math.Exp(-2 * glass.Absorption.Vec.Axis(i)); math.Abs(got-want) > 1e-9 {
+			t.Errorf("expected channel %d to be %v after 2 units, got %v", i, want, got)
+		}
+	}
+}
+
+func TestLoadSceneTextures(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.White)
+	img.Set(1, 0, color.Black)
+	f, err := os.Create(filepath.Join(dir, "stripes.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	scene := `{
+		"materials": {
+			"checkered": {
+				"type": "lambertian",
+				"albedo": {"type": "checker", "scale": 2, "even": [1, 0, 0], "odd": {"type": "image", "path": "stripes.png"}}
+			}
+		},
+		"objects": [{"type": "sphere", "radius": 1, "material": "checkered"}]
+	}`
+	world, _, err := loadScene(strings.NewReader(scene), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checker, ok := world[0].(Sphere).Material.(Lambertian).Albedo.(CheckerTexture)
+	if !ok {
+		t.Fatalf("expected a checker texture, got %+v", world[0])
+	}
+	if got := checker.Value(0, 0, vec.New(1, 1, 1)); got != newColor(1, 0, 0) {
+		t.Errorf("expected the even texture to be red, got %v", got)
+	}
+	if got := checker.Value(0.25, 0.5, vec.New(3, 1, 1)); got != white {
+		t.Errorf("expected the left half of the image to be white, got %v", got)
+	}
+	if got := checker.Value(0.75, 0.5, vec.New(3, 1, 1)); got != black {
+		t.Errorf("expected the right half of the image to be black, got %v", got)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/noise"
+)
+
+// Texture is a color that varies over the surface of an object.
+type Texture interface {
+	// Value returns the color at texture coordinates (u, v), which is also at
+	// point p in the world.
+	Value(u, v float64, p Vec3) Color
+}
+
+// Value makes a Color a Texture that's the same color everywhere.
+func (c Color) Value(u, v float64, p Vec3) Color {
+	return c
+}
+
+// CheckerTexture alternates between two textures in a 3D checkerboard of
+// cubes. Because it's based on where points are in the world rather than their
+// texture coordinates, it looks the same on any object.
+type CheckerTexture struct {
+	// Scale is the width of each cube
+	Scale float64
+	Even  Texture
+	Odd   Texture
+}
+
+func (c CheckerTexture) Value(u, v float64, p Vec3) Color {
+	x := int(math.Floor(p.X / c.Scale))
+	y := int(math.Floor(p.Y / c.Scale))
+	z := int(math.Floor(p.Z / c.Scale))
+	if (x+y+z)%2 == 0 {
+		return c.Even.Value(u, v, p)
+	}
+	return c.Odd.Value(u, v, p)
+}
+
+// ImageTexture wraps an image around an object according to its texture
+// coordinates.
+type ImageTexture struct {
+	width  int
+	height int
+	// pixels are the linear colors of the image from the top left, row by row
+	pixels []Color
+}
+
+// LoadImageTexture reads a PNG or JPEG file to use as a texture.
+func LoadImageTexture(path string) (*ImageTexture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewImageTexture(img), nil
+}
+
+// NewImageTexture converts img to a texture. img is assumed to be sRGB encoded
+// like the images that the camera renders.
+func NewImageTexture(img image.Image) *ImageTexture {
+	bounds := img.Bounds()
+	texture := &ImageTexture{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		pixels: make([]Color, 0, bounds.Dx()*bounds.Dy()),
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			texture.pixels = append(texture.pixels, newColor(
+				sRGBToLinear(float64(r)/math.MaxUint16),
+				sRGBToLinear(float64(g)/math.MaxUint16),
+				sRGBToLinear(float64(b)/math.MaxUint16),
+			))
+		}
+	}
+	return texture
+}
+
+func (t *ImageTexture) Value(u, v float64, p Vec3) Color {
+	if t.width == 0 || t.height == 0 {
+		// make it obvious that something's wrong
+		return newColor(0, 1, 1)
+	}
+
+	u = min(max(u, 0), 1)
+	// v goes from bottom to top, but images go from top to bottom
+	v = 1 - min(max(v, 0), 1)
+	i := min(int(u*float64(t.width)), t.width-1)
+	j := min(int(v*float64(t.height)), t.height-1)
+	return t.pixels[j*t.width+i]
+}
+
+// NoiseTexture blends between two textures with Perlin noise, which looks like
+// clouds or stone.
+type NoiseTexture struct {
+	Noise *noise.Perlin
+	// Scale is roughly the size of the blobs, and Octaves is how many layers
+	// of finer detail are added to them. Octaves must be at least 1.
+	Scale   float64
+	Octaves int
+	Light   Texture
+	Dark    Texture
+}
+
+func (n NoiseTexture) Value(u, v float64, p Vec3) Color {
+	value := n.Noise.FBM(p.Divide(n.Scale), n.Octaves, 2, 0.5)
+	return blendTextures(n.Dark, n.Light, 0.5*(1+value), u, v, p)
+}
+
+// MarbleTexture is bands of Dark that run across Light along the Z axis and
+// are twisted by turbulence into veins.
+type MarbleTexture struct {
+	Noise *noise.Perlin
+	// Scale is roughly the width of the bands, and Octaves is how many layers
+	// of turbulence twist them. Octaves must be at least 1.
+	Scale   float64
+	Octaves int
+	Light   Texture
+	Dark    Texture
+}
+
+func (m MarbleTexture) Value(u, v float64, p Vec3) Color {
+	// The turbulence is much coarser than the bands, so it bends them into
+	// long veins instead of breaking them up.
+	q := p.Divide(m.Scale)
+	value := math.Sin(q.Z + 6*m.Noise.Turbulence(q.Scale(0.25), m.Octaves))
+	return blendTextures(m.Dark, m.Light, 0.5*(1+value), u, v, p)
+}
+
+// WoodTexture is the rings of a tree trunk that grew along the Y axis, wobbled
+// a little by noise. Each ring fades from Light to Dark as it goes out.
+type WoodTexture struct {
+	Noise *noise.Perlin
+	// Scale is the width of each ring, and Octaves is how many layers of noise
+	// distort them. Octaves must be at least 1.
+	Scale   float64
+	Octaves int
+	Light   Texture
+	Dark    Texture
+}
+
+func (w WoodTexture) Value(u, v float64, p Vec3) Color {
+	q := p.Divide(w.Scale)
+	radius := math.Hypot(q.X, q.Z) + 0.5*w.Noise.FBM(q, w.Octaves, 2, 0.5)
+	return blendTextures(w.Light, w.Dark, fract(radius), u, v, p)
+}
+
+// blendTextures mixes a and b, going from all a when t is 0 to all b when t is
+// 1.
+func blendTextures(a, b Texture, t float64, u, v float64, p Vec3) Color {
+	t = min(max(t, 0), 1)
+	return Color{a.Value(u, v, p).Vec.Scale(1 - t).Add(b.Value(u, v, p).Vec.Scale(t))}
+}
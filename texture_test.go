@@ -0,0 +1,97 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestCheckerTexture(t *testing.T) {
+	even, odd := newColor(1, 0, 0), newColor(0, 0, 1)
+	checker := CheckerTexture{Scale: 2, Even: even, Odd: odd}
+	tests := []struct {
+		p    Vec3
+		want Color
+	}{
+		{vec.New(0.5, 0.5, 0.5), even},
+		{vec.New(2.5, 0.5, 0.5), odd},
+		{vec.New(2.5, 2.5, 0.5), even},
+		// the cubes on the negative side of each axis continue the pattern
+		// instead of mirroring it
+		{vec.New(-0.5, 0.5, 0.5), odd},
+		{vec.New(-2.5, 0.5, 0.5), even},
+		{vec.New(-0.5, -0.5, 0.5), even},
+		{vec.New(-0.5, -0.5, -0.5), odd},
+		{vec.New(-2.5, -0.5, -2.5), odd},
+	}
+	for _, test := range tests {
+		if got := checker.Value(0, 0, test.p); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.p, got, test.want)
+		}
+	}
+}
+
+func TestImageTextureCoordinates(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 255, 0, 255})
+	img.SetNRGBA(0, 1, color.NRGBA{0, 0, 255, 255})
+	img.SetNRGBA(1, 1, color.NRGBA{255, 255, 255, 255})
+	texture := NewImageTexture(img)
+
+	red, green, blue := newColor(1, 0, 0), newColor(0, 1, 0), newColor(0, 0, 1)
+	tests := []struct {
+		u, v float64
+		want Color
+	}{
+		// v goes up from the bottom of the image
+		{0, 0, blue},
+		{1, 0, white},
+		{0, 1, red},
+		{1, 1, green},
+		{0.25, 0.75, red},
+		{0.75, 0.25, white},
+		// coordinates outside [0,1] are clamped to the nearest edge rather
+		// than wrapped around
+		{-0.5, 2, red},
+		{1.5, -3, white},
+		{1.25, 0.75, green},
+		{0.25, -0.25, blue},
+	}
+	for _, test := range tests {
+		if got := texture.Value(test.u, test.v, Vec3{}); got != test.want {
+			t.Errorf("(%v, %v): got %v, want %v", test.u, test.v, got, test.want)
+		}
+	}
+}
+
+func TestImageTextureIsLinear(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 3, 1))
+	img.SetGray(0, 0, color.Gray{0})
+	img.SetGray(1, 0, color.Gray{10})
+	img.SetGray(2, 0, color.Gray{128})
+	texture := NewImageTexture(img)
+
+	tests := []struct {
+		u    float64
+		want float64
+	}{
+		{0.1, 0},
+		// dark values are on the linear part of the sRGB curve
+		{0.5, 10. / 255 / 12.92},
+		// sRGB middle gray is much darker in linear light
+		{0.9, 0.2158605},
+	}
+	for _, test := range tests {
+		got := texture.Value(test.u, 0.5, Vec3{})
+		for _, component := range []float64{got.R(), got.G(), got.B()} {
+			if math.Abs(component-test.want) > 1e-6 {
+				t.Errorf("u=%v: got %v, want %v in every channel", test.u, got, test.want)
+				break
+			}
+		}
+	}
+}
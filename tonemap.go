@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ToneMap is a way of squeezing the unbounded colors that a render produces
+// into the range that can be displayed.
+type ToneMap int
+
+const (
+	// ToneMapClamp clips anything brighter than 1 to 1. Bright areas lose
+	// their detail and saturated colors turn white.
+	ToneMapClamp ToneMap = iota
+	// ToneMapReinhard maps each channel c to c/(1+c), which compresses bright
+	// colors smoothly but makes the whole image a bit flat.
+	ToneMapReinhard
+	// ToneMapACES is an approximation of the filmic curve from the Academy
+	// Color Encoding System. It has more contrast than Reinhard and rolls off
+	// highlights to white like film does.
+	ToneMapACES
+)
+
+// ParseToneMap returns the ToneMap called name, which is one of "clamp",
+// "reinhard", or "aces".
+func ParseToneMap(name string) (ToneMap, error) {
+	switch name {
+	case "clamp":
+		return ToneMapClamp, nil
+	case "reinhard":
+		return ToneMapReinhard, nil
+	case "aces":
+		return ToneMapACES, nil
+	}
+	return 0, fmt.Errorf("unknown tone map %q. It must be 'clamp', 'reinhard', or 'aces'", name)
+}
+
+func (t ToneMap) String() string {
+	switch t {
+	case ToneMapClamp:
+		return "clamp"
+	case ToneMapReinhard:
+		return "reinhard"
+	case ToneMapACES:
+		return "aces"
+	}
+	return fmt.Sprintf("ToneMap(%d)", int(t))
+}
+
+// apply maps a linear color to a linear color in the range [0,1]. Channels
+// that are negative or NaN, which a buggy material could produce, become 0
+// rather than spoiling the image.
+func (t ToneMap) apply(c Color) Color {
+	return newColor(t.applyComponent(c.R()), t.applyComponent(c.G()), t.applyComponent(c.B()))
+}
+
+func (t ToneMap) applyComponent(x float64) float64 {
+	if !isValidColor(x) {
+		return 0
+	}
+	if math.IsInf(x, 1) {
+		return 1
+	}
+	switch t {
+	case ToneMapReinhard:
+		x = x / (1 + x)
+	case ToneMapACES:
+		// This is Krzysztof Narkowicz's fit of the ACES curve.
+		const (
+			a = 2.51
+			b = 0.03
+			c = 2.43
+			d = 0.59
+			e = 0.14
+		)
+		x = (x * (a*x + b)) / (x*(c*x+d) + e)
+	}
+	return min(x, 1)
+}
+
+// exposed scales c by 2^stops, so every stop of exposure doubles how bright
+// the image is.
+func exposed(c Color, stops float64) Color {
+	if stops == 0 {
+		return c
+	}
+	return Color{c.Vec.Scale(math.Exp2(stops))}
+}
+
+// linearToSRGB applies the sRGB transfer curve to a linear component in the
+// range [0,1], which is how images are expected to be encoded.
+func linearToSRGB(component float64) float64 {
+	if component <= 0.0031308 {
+		return max(12.92*component, 0)
+	}
+	return 1.055*math.Pow(component, 1/2.4) - 0.055
+}
+
+// sRGBToLinear undoes linearToSRGB.
+func sRGBToLinear(component float64) float64 {
+	if component <= 0.04045 {
+		return component / 12.92
+	}
+	return math.Pow((component+0.055)/1.055, 2.4)
+}
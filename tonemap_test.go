@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToneMapsStayInRange(t *testing.T) {
+	inputs := []float64{math.NaN(), -1, 0, 0.001, 0.18, 0.5, 1, 2, 10, 1000, math.Inf(1)}
+	for _, toneMap := range []ToneMap{ToneMapClamp, ToneMapReinhard, ToneMapACES} {
+		previous := 0.
+		for _, x := range inputs {
+			got := toneMap.applyComponent(x)
+			if got < 0 || got > 1 || math.IsNaN(got) {
+				t.Errorf("%v maps %v to %v, which is out of range", toneMap, x, got)
+			}
+			if isValidColor(x) && got < previous {
+				t.Errorf("%v maps %v to %v, which is darker than something dimmer", toneMap, x, got)
+			}
+			previous = got
+		}
+	}
+}
+
+func TestParseToneMap(t *testing.T) {
+	for _, toneMap := range []ToneMap{ToneMapClamp, ToneMapReinhard, ToneMapACES} {
+		got, err := ParseToneMap(toneMap.String())
+		if err != nil || got != toneMap {
+			t.Errorf("ParseToneMap(%q) = %v, %v", toneMap.String(), got, err)
+		}
+	}
+	if _, err := ParseToneMap("filmic"); err == nil {
+		t.Error("ParseToneMap should reject unknown names")
+	}
+}
+
+func TestSRGBRoundTrip(t *testing.T) {
+	for x := 0.; x <= 1; x += 0.001 {
+		if got := sRGBToLinear(linearToSRGB(x)); math.Abs(got-x) > 1e-9 {
+			t.Fatalf("sRGBToLinear(linearToSRGB(%v)) = %v", x, got)
+		}
+	}
+}
+
+func TestBrightColorsDontPanic(t *testing.T) {
+	got := newColor(5, math.Inf(1), math.NaN()).NRGBA64()
+	if got.R != math.MaxUint16 || got.G != math.MaxUint16 || got.B != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestExposure(t *testing.T) {
+	if got := exposed(newColor(0.25, 0.5, 1), 1); got != newColor(0.5, 1, 2) {
+		t.Errorf("one stop of exposure should double the color, got %v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+type Mat4 = vec.Mat4
+
+// Transformed is an instance of another Hittable that has been moved, rotated,
+// and/or scaled. The object itself isn't changed, so one copy of a mesh can be
+// placed all over a scene by wrapping it in many Transformeds.
+type Transformed struct {
+	object Hittable
+	// toWorld takes points from the object's space to the world, and toObject
+	// goes the other way.
+	toWorld  Mat4
+	toObject Mat4
+	// normalToWorld is the inverse transpose of toWorld, which keeps normals
+	// perpendicular to the surface even when it's scaled unevenly.
+	normalToWorld Mat4
+	box           AABB
+}
+
+// NewTransformed places object in the world with transform, e.g.
+//
+//	vec.Translation(offset).Multiply(vec.RotationY(angle))
+//
+// rotates object around the Y axis and then moves it by offset. It panics if
+// transform can't be undone, like a scale of 0.
+func NewTransformed(object Hittable, transform Mat4) *Transformed {
+	inverse, ok := transform.Inverse()
+	if !ok {
+		panic("a transform must be invertible")
+	}
+	t := &Transformed{
+		object:        object,
+		toWorld:       transform,
+		toObject:      inverse,
+		normalToWorld: inverse.Transpose(),
+	}
+	t.box = t.worldBox(object.BoundingBox())
+	return t
+}
+
+// worldBox returns a box in the world that contains objectBox.
+func (t *Transformed) worldBox(objectBox AABB) AABB {
+	if !objectBox.isBounded() {
+		// rotating an infinite box doesn't give anything useful
+		return AABB{
+			Min: vec.New(math.Inf(-1), math.Inf(-1), math.Inf(-1)),
+			Max: vec.New(math.Inf(1), math.Inf(1), math.Inf(1)),
+		}
+	}
+	box := emptyAABB
+	for i := range 8 {
+		corner := objectBox.Min
+		if i&1 != 0 {
+			corner.X = objectBox.Max.X
+		}
+		if i&2 != 0 {
+			corner.Y = objectBox.Max.Y
+		}
+		if i&4 != 0 {
+			corner.Z = objectBox.Max.Z
+		}
+		corner = t.toWorld.Point(corner)
+		box = box.Union(AABB{corner, corner})
+	}
+	return box
+}
+
+func (t *Transformed) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	// The direction isn't normalized, so t along the object space ray is the
+	// same as t along the world ray.
+	objectRay := Ray{t.toObject.Point(ray.Origin), t.toObject.Direction(ray.Direction), ray.Time}
+	hit, record := t.object.Hit(objectRay, tMin, tMax, rng)
+	if !hit {
+		return false, HitRecord{}
+	}
+
+	// The normal already points against the object space ray, and it still
+	// points against the world ray once it's transformed, so Exterior doesn't
+	// change.
+	record.Ray = ray
+	record.HitPoint = t.toWorld.Point(record.HitPoint)
+	record.Normal = t.normalToWorld.Direction(record.Normal).UnitVector()
+	// the object is a Light in its own space, not the world's
+	record.Light = nil
+	return true, record
+}
+
+func (t *Transformed) BoundingBox() AABB {
+	return t.box
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/Anthony-Fiddes/raytracing-1w/vec"
+)
+
+func TestTransformedMatchesBakedGeometry(t *testing.T) {
+	// A unit box that's scaled, rotated, and moved should be hit in exactly
+	// the same places as the box it turns into.
+	unit := NewBox(vec.New(0, 0, 0), vec.New(1, 1, 1), Lambertian{white})
+	transform := vec.Translation(vec.New(3, -1, 2)).
+		Multiply(vec.RotationY(math.Pi / 2)).
+		Multiply(vec.Scaling(vec.New(2, 3, 4)))
+	instance := NewTransformed(unit, transform)
+	// rotating (2, 3, 4) by 90 degrees around Y swaps the X and Z extents
+	baked := NewBox(vec.New(3, -1, 2), vec.New(7, 2, 0), Lambertian{white})
+
+	rng := rand.New(rand.NewPCG(7, 8))
+	hits := 0
+	for range 2000 {
+		ray := Ray{Origin: vec.RandomRange(rng, -10, 10), Direction: vec.RandomUnit(rng)}
+		instanceHit, instanceRecord := instance.Hit(ray, 0.001, math.Inf(1), nil)
+		bakedHit, bakedRecord := baked.Hit(ray, 0.001, math.Inf(1), nil)
+		if instanceHit != bakedHit {
+			t.Fatalf("ray %+v: instance hit is %v but baked hit is %v", ray, instanceHit, bakedHit)
+		}
+		if !instanceHit {
+			continue
+		}
+		hits++
+		if math.Abs(instanceRecord.T-bakedRecord.T) > 1e-9 {
+			t.Fatalf("ray %+v: instance hit at t=%v but baked hit at t=%v", ray, instanceRecord.T, bakedRecord.T)
+		}
+		if instanceRecord.HitPoint.Subtract(bakedRecord.HitPoint).Length() > 1e-9 {
+			t.Fatalf("ray %+v: instance hit %v but baked hit %v", ray, instanceRecord.HitPoint, bakedRecord.HitPoint)
+		}
+		if instanceRecord.Normal.Subtract(bakedRecord.Normal).Length() > 1e-9 {
+			t.Fatalf("ray %+v: instance normal is %v but baked normal is %v", ray, instanceRecord.Normal, bakedRecord.Normal)
+		}
+		if instanceRecord.Exterior != bakedRecord.Exterior {
+			t.Fatalf("ray %+v: instance exterior is %v but baked exterior is %v", ray, instanceRecord.Exterior, bakedRecord.Exterior)
+		}
+	}
+	if hits == 0 {
+		t.Fatal("no rays hit the box")
+	}
+
+	// the sides of the box are padded a little
+	box := instance.BoundingBox()
+	if box.Min.Subtract(vec.New(3, -1, 0)).Length() > 1e-3 || box.Max.Subtract(vec.New(7, 2, 2)).Length() > 1e-3 {
+		t.Errorf("unexpected bounding box %+v", box)
+	}
+}
+
+func TestScaledSphereNormal(t *testing.T) {
+	// stretching a unit sphere into an ellipsoid has to tilt its normals
+	sphere := Sphere{vec.New(0, 0, 0), 1, Lambertian{white}}
+	ellipsoid := NewTransformed(sphere, vec.Scaling(vec.New(2, 1, 1)))
+	p := vec.New(math.Sqrt2, math.Sqrt2/2, 0)
+	hit, record := ellipsoid.Hit(Ray{Origin: p.Scale(2), Direction: p.Scale(-1)}, 0.001, math.Inf(1), nil)
+	if !hit {
+		t.Fatal("expected a hit")
+	}
+	// the gradient of x²/4 + y² at p
+	want := vec.New(p.X/2, 2*p.Y, 0).UnitVector()
+	if record.Normal.Subtract(want).Length() > 1e-9 {
+		t.Errorf("expected normal %v, got %v", want, record.Normal)
+	}
+}
+
+func TestMat4Inverse(t *testing.T) {
+	m := vec.Translation(vec.New(1, 2, 3)).
+		Multiply(vec.RotationX(0.3)).
+		Multiply(vec.RotationZ(-1.2)).
+		Multiply(vec.Scaling(vec.New(0.5, 2, 3)))
+	inverse, ok := m.Inverse()
+	if !ok {
+		t.Fatal("expected m to be invertible")
+	}
+	product := m.Multiply(inverse)
+	identity := vec.Identity()
+	for i := range 4 {
+		for j := range 4 {
+			if math.Abs(product[i][j]-identity[i][j]) > 1e-12 {
+				t.Fatalf("m * inverse is %v", product)
+			}
+		}
+	}
+	if _, ok := vec.Scaling(vec.New(1, 0, 1)).Inverse(); ok {
+		t.Error("a scale of 0 shouldn't be invertible")
+	}
+}
+
+func TestLoadSceneTransforms(t *testing.T) {
+	scene := `{
+		"materials": {"m": {"type": "lambertian"}},
+		"objects": [
+			{"type": "mesh", "path": "pyramid.obj", "transform": [{"translate": [5, 0, 0]}]},
+			{"type": "mesh", "path": "pyramid.obj", "transform": [{"rotate_y": 45}, {"scale": [2, 2, 2]}]},
+			{"type": "sphere", "radius": 1, "material": "m", "transform": [{"translate": [0, 1, 0]}]}
+		]
+	}`
+	world, _, err := loadScene(strings.NewReader(scene), "scenes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, ok1 := world[0].(*Transformed)
+	second, ok2 := world[1].(*Transformed)
+	if !ok1 || !ok2 {
+		t.Fatal("expected the meshes to be transformed")
+	}
+	if first.object != second.object {
+		t.Error("expected both instances to share one copy of the mesh")
+	}
+	if hit, _ := world[2].Hit(Ray{Origin: vec.New(0, 5, 0), Direction: vec.New(0, -1, 0)}, 0.001, math.Inf(1), nil); !hit {
+		t.Error("expected to hit the moved sphere")
+	}
+
+	_, _, err = loadScene(strings.NewReader(`{
+		"materials": {"m": {"type": "lambertian"}},
+		"objects": [{"type": "sphere", "radius": 1, "material": "m", "transform": [{"translate": [1, 0, 0], "rotate_x": 90}]}]
+	}`), ".")
+	if err == nil || !strings.Contains(err.Error(), "object 0: transform 0: must have exactly one of") {
+		t.Errorf("expected an error about the transform step, got %v", err)
+	}
+}
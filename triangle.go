@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// TexCoord is a point on a texture. U goes across the texture from left to
+// right and V goes from bottom to top, both in the range [0,1].
+type TexCoord struct {
+	U float64
+	V float64
+}
+
+// Triangle is a flat triangle with corners A, B, and C. Its outward normal
+// points towards whoever sees A, B, and C in counter-clockwise order.
+type Triangle struct {
+	A Vec3
+	B Vec3
+	C Vec3
+	// NormalA, NormalB, and NormalC are optional normals at each corner. If
+	// they're set, they're interpolated across the triangle so that a mesh can
+	// look smooth instead of faceted. They should point roughly the same way as
+	// the outward normal and must be unit vectors or zero. Where they add up to
+	// nothing, the flat normal is used.
+	NormalA Vec3
+	NormalB Vec3
+	NormalC Vec3
+	// TexA, TexB, and TexC are optional texture coordinates at each corner. If
+	// they're all zero, A, B, and C are mapped to (0,0), (1,0), and (0,1).
+	TexA     TexCoord
+	TexB     TexCoord
+	TexC     TexCoord
+	Material Material
+}
+
+func (tri Triangle) Hit(ray Ray, tMin float64, tMax float64, rng *rand.Rand) (bool, HitRecord) {
+	// This is the Möller–Trumbore algorithm. It solves
+	//
+	// Q + td = (1-u-v)A + uB + vC
+	//
+	// for t, u, and v, where u and v are the barycentric coordinates of the hit
+	// point. The ray only hits the triangle if u >= 0, v >= 0, and u + v <= 1.
+	edgeAB := tri.B.Subtract(tri.A)
+	edgeAC := tri.C.Subtract(tri.A)
+	p := ray.Direction.Cross(edgeAC)
+	determinant := edgeAB.Dot(p)
+	if math.Abs(determinant) < 1e-12 {
+		// the ray is parallel to the triangle
+		return false, HitRecord{}
+	}
+	inverseDeterminant := 1 / determinant
+
+	s := ray.Origin.Subtract(tri.A)
+	u := s.Dot(p) * inverseDeterminant
+	if u < 0 || u > 1 {
+		return false, HitRecord{}
+	}
+	q := s.Cross(edgeAB)
+	v := ray.Direction.Dot(q) * inverseDeterminant
+	if v < 0 || u+v > 1 {
+		return false, HitRecord{}
+	}
+	t := edgeAC.Dot(q) * inverseDeterminant
+	if t <= tMin || tMax <= t {
+		return false, HitRecord{}
+	}
+
+	w := 1 - u - v
+	outwardNormal := edgeAB.Cross(edgeAC).UnitVector()
+	record := NewHitRecord(ray, t, outwardNormal, ray.At(t), tri.Material)
+
+	var emptyVec Vec3
+	if tri.NormalA != emptyVec || tri.NormalB != emptyVec || tri.NormalC != emptyVec {
+		smooth := tri.NormalA.Scale(w).Add(tri.NormalB.Scale(u)).Add(tri.NormalC.Scale(v))
+		// Corner normals that are zero, or that cancel out, have no direction
+		// to give, so the flat normal is kept instead of a NaN one.
+		if smooth.Length() > 1e-8 {
+			smooth = smooth.UnitVector()
+			// keep the smooth normal on the same side as the flat one so that
+			// it still points against the ray
+			if smooth.Dot(record.Normal) < 0 {
+				smooth = smooth.Scale(-1)
+			}
+			record.Normal = smooth
+		}
+	}
+
+	record.U, record.V = tri.texCoords(u, v)
+	return true, record
+}
+
+// texCoords returns the texture coordinates of the point with barycentric
+// coordinates u and v, where u is the weight of B and v is the weight of C.
+func (tri Triangle) texCoords(u, v float64) (float64, float64) {
+	var emptyTex TexCoord
+	if tri.TexA == emptyTex && tri.TexB == emptyTex && tri.TexC == emptyTex {
+		return u, v
+	}
+	w := 1 - u - v
+	return w*tri.TexA.U + u*tri.TexB.U + v*tri.TexC.U, w*tri.TexA.V + u*tri.TexB.V + v*tri.TexC.V
+}
+
+func (tri Triangle) BoundingBox() AABB {
+	box := NewAABB(tri.A, tri.B)
+	box = box.Union(AABB{tri.C, tri.C})
+	return box.padded(1e-4)
+}
@@ -0,0 +1,145 @@
+package vec
+
+import "math"
+
+// Mat4 is a 4x4 matrix for affine transforms of points and directions. It's
+// indexed by row and then column, and it multiplies column vectors, so the
+// translation is in the last column.
+type Mat4 [4][4]float64
+
+// Identity returns the transform that leaves everything where it is.
+func Identity() Mat4 {
+	return Mat4{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Translation returns the transform that moves points by offset.
+func Translation(offset Vec3) Mat4 {
+	m := Identity()
+	m[0][3] = offset.X
+	m[1][3] = offset.Y
+	m[2][3] = offset.Z
+	return m
+}
+
+// Scaling returns the transform that scales each axis by the matching
+// component of factors.
+func Scaling(factors Vec3) Mat4 {
+	m := Identity()
+	m[0][0] = factors.X
+	m[1][1] = factors.Y
+	m[2][2] = factors.Z
+	return m
+}
+
+// RotationX returns the transform that rotates counter-clockwise by radians
+// around the X axis, as seen from +X looking back at the origin.
+func RotationX(radians float64) Mat4 {
+	sin, cos := math.Sincos(radians)
+	m := Identity()
+	m[1][1], m[1][2] = cos, -sin
+	m[2][1], m[2][2] = sin, cos
+	return m
+}
+
+// RotationY is like RotationX, but around the Y axis.
+func RotationY(radians float64) Mat4 {
+	sin, cos := math.Sincos(radians)
+	m := Identity()
+	m[0][0], m[0][2] = cos, sin
+	m[2][0], m[2][2] = -sin, cos
+	return m
+}
+
+// RotationZ is like RotationX, but around the Z axis.
+func RotationZ(radians float64) Mat4 {
+	sin, cos := math.Sincos(radians)
+	m := Identity()
+	m[0][0], m[0][1] = cos, -sin
+	m[1][0], m[1][1] = sin, cos
+	return m
+}
+
+// Multiply returns m * other, which is the transform that applies other and
+// then m.
+func (m Mat4) Multiply(other Mat4) Mat4 {
+	var result Mat4
+	for i := range 4 {
+		for j := range 4 {
+			for k := range 4 {
+				result[i][j] += m[i][k] * other[k][j]
+			}
+		}
+	}
+	return result
+}
+
+func (m Mat4) Transpose() Mat4 {
+	var result Mat4
+	for i := range 4 {
+		for j := range 4 {
+			result[i][j] = m[j][i]
+		}
+	}
+	return result
+}
+
+// Inverse returns the transform that undoes m. ok is false if m squashes space
+// flat (e.g. it scales an axis by 0), so it can't be undone.
+func (m Mat4) Inverse() (inverse Mat4, ok bool) {
+	// This is Gauss-Jordan elimination with partial pivoting, which turns m
+	// into the identity while doing the same row operations to inverse.
+	inverse = Identity()
+	for column := range 4 {
+		pivot := column
+		for row := column + 1; row < 4; row++ {
+			if math.Abs(m[row][column]) > math.Abs(m[pivot][column]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][column]) < 1e-12 {
+			return Mat4{}, false
+		}
+		m[column], m[pivot] = m[pivot], m[column]
+		inverse[column], inverse[pivot] = inverse[pivot], inverse[column]
+
+		scale := 1 / m[column][column]
+		for j := range 4 {
+			m[column][j] *= scale
+			inverse[column][j] *= scale
+		}
+		for row := range 4 {
+			if row == column {
+				continue
+			}
+			factor := m[row][column]
+			for j := range 4 {
+				m[row][j] -= factor * m[column][j]
+				inverse[row][j] -= factor * inverse[column][j]
+			}
+		}
+	}
+	return inverse, true
+}
+
+// Point transforms the point p, including any translation.
+func (m Mat4) Point(p Vec3) Vec3 {
+	return New(
+		m[0][0]*p.X+m[0][1]*p.Y+m[0][2]*p.Z+m[0][3],
+		m[1][0]*p.X+m[1][1]*p.Y+m[1][2]*p.Z+m[1][3],
+		m[2][0]*p.X+m[2][1]*p.Y+m[2][2]*p.Z+m[2][3],
+	)
+}
+
+// Direction transforms the direction d, which isn't affected by translation.
+func (m Mat4) Direction(d Vec3) Vec3 {
+	return New(
+		m[0][0]*d.X+m[0][1]*d.Y+m[0][2]*d.Z,
+		m[1][0]*d.X+m[1][1]*d.Y+m[1][2]*d.Z,
+		m[2][0]*d.X+m[2][1]*d.Y+m[2][2]*d.Z,
+	)
+}
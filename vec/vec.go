@@ -2,7 +2,7 @@ package vec
 
 import (
 	"math"
-	"math/rand"
+	"math/rand/v2"
 )
 
 type Vec3 struct {
@@ -74,25 +74,66 @@ func (v Vec3) Dot(other Vec3) float64 {
 	return v.X*other.X + v.Y*other.Y + v.Z*other.Z
 }
 
-func Random() Vec3 {
-	return New(rand.Float64(), rand.Float64(), rand.Float64())
+// Axis returns the X, Y, or Z component of v for an axis of 0, 1, or 2
+// respectively.
+func (v Vec3) Axis(axis int) float64 {
+	switch axis {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	case 2:
+		return v.Z
+	}
+	panic("axis must be 0, 1, or 2")
+}
+
+// Min returns the component-wise minimum of a and b.
+func Min(a, b Vec3) Vec3 {
+	return New(min(a.X, b.X), min(a.Y, b.Y), min(a.Z, b.Z))
+}
+
+// Max returns the component-wise maximum of a and b.
+func Max(a, b Vec3) Vec3 {
+	return New(max(a.X, b.X), max(a.Y, b.Y), max(a.Z, b.Z))
+}
+
+// Basis returns two unit vectors that are perpendicular to each other and to
+// the unit vector n, so together with n they form an orthonormal basis.
+func Basis(n Vec3) (tangent, bitangent Vec3) {
+	// This is the branchless construction from "Building an Orthonormal
+	// Basis, Revisited" by Duff et al.
+	sign := math.Copysign(1, n.Z)
+	a := -1 / (sign + n.Z)
+	b := n.X * n.Y * a
+	tangent = New(1+sign*n.X*n.X*a, sign*b, -sign*n.X)
+	bitangent = New(b, sign+n.Y*n.Y*a, -n.Y)
+	return tangent, bitangent
+}
+
+// The random functions below all draw from rng rather than the global source
+// so that callers can control how they're seeded and avoid contending over a
+// shared lock.
+
+func Random(rng *rand.Rand) Vec3 {
+	return New(rng.Float64(), rng.Float64(), rng.Float64())
 }
 
-func randFloatRange(min, max float64) float64 {
-	return (max-min)*rand.Float64() + min
+func randFloatRange(rng *rand.Rand, min, max float64) float64 {
+	return (max-min)*rng.Float64() + min
 }
 
-func RandomRange(min, max float64) Vec3 {
+func RandomRange(rng *rand.Rand, min, max float64) Vec3 {
 	return Vec3{
-		randFloatRange(min, max),
-		randFloatRange(min, max),
-		randFloatRange(min, max),
+		randFloatRange(rng, min, max),
+		randFloatRange(rng, min, max),
+		randFloatRange(rng, min, max),
 	}
 }
 
-func RandomUnit() Vec3 {
+func RandomUnit(rng *rand.Rand) Vec3 {
 	for {
-		p := RandomRange(-1, 1)
+		p := RandomRange(rng, -1, 1)
 		// We care about length < 1, but length^2 < 1^2 also holds and we can
 		// avoid a square root.
 		if p.LengthSquared() < 1 {
@@ -101,17 +142,17 @@ func RandomUnit() Vec3 {
 	}
 }
 
-func RandomDisk() Vec3 {
+func RandomDisk(rng *rand.Rand) Vec3 {
 	for {
-		p := New(randFloatRange(-1, 1), randFloatRange(-1, 1), 0)
+		p := New(randFloatRange(rng, -1, 1), randFloatRange(rng, -1, 1), 0)
 		if p.LengthSquared() <= 1 {
 			return p
 		}
 	}
 }
 
-func RandomUnitHemisphere(normal Vec3) Vec3 {
-	result := RandomUnit()
+func RandomUnitHemisphere(rng *rand.Rand, normal Vec3) Vec3 {
+	result := RandomUnit(rng)
 	if result.Dot(normal) > 0. {
 		return result
 	}